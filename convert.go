@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/soniah/gosnmp"
+)
+
+// conversions that can be configured for a column, e.g., 'convert = ifPhysAddress:hex'
+var conversions = map[string]bool{
+	"string": true,
+	"hex":    true,
+	"int":    true,
+	"uint":   true,
+	"float":  true,
+	"ticks":  true, // TimeTicks as hundredths of seconds
+}
+
+// parseConversions validates the per column overrides
+func parseConversions(list []string) (map[string]string, error) {
+	conv := make(map[string]string)
+	for _, c := range list {
+		i := strings.LastIndex(c, ":")
+		if i < 0 || !conversions[c[i+1:]] {
+			return nil, fmt.Errorf("invalid conversion: %s", c)
+		}
+		conv[c[:i]] = c[i+1:]
+	}
+	return conv, nil
+}
+
+// printable reports if the octets are text rather than binary data
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func hexString(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02x", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+func toBig(v interface{}) (*big.Int, bool) {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return gosnmp.ToBigInt(v), true
+	}
+	return nil, false
+}
+
+// convertValue gives each pdu type a stable go type so that influx
+// doesn't get conflicting field types for the same column:
+//
+//	INTEGER, Counter32, Gauge32, Unsigned32: int64
+//	Counter64: uint64
+//	TimeTicks: float64 seconds
+//	OCTET STRING: string if printable, otherwise hex
+//	IpAddress, OBJECT IDENTIFIER: dotted string
+//	Opaque float and double: float64
+//
+// or the type given by 'as'. Values that are missing are returned as nil
+func convertValue(kind gosnmp.Asn1BER, v interface{}, as string) interface{} {
+	switch kind {
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil
+	}
+	if v == nil {
+		return nil
+	}
+	if n, ok := toBig(v); ok {
+		switch as {
+		case "":
+		case "int":
+			return n.Int64()
+		case "uint":
+			return n.Uint64()
+		case "float":
+			f, _ := new(big.Float).SetInt(n).Float64()
+			return f
+		case "ticks":
+			return n.Int64()
+		case "string", "hex":
+			return n.String()
+		}
+		switch kind {
+		case gosnmp.Counter64:
+			return n.Uint64()
+		case gosnmp.TimeTicks:
+			return float64(n.Int64()) / 100
+		}
+		return n.Int64()
+	}
+	switch v := v.(type) {
+	case []byte:
+		switch as {
+		case "hex":
+			return hexString(v)
+		case "string":
+			return string(bytes.TrimRight(v, "\x00"))
+		case "int", "uint", "float":
+			// numbers sent as text
+			if f, err := strconv.ParseFloat(strings.TrimSpace(string(bytes.TrimRight(v, "\x00"))), 64); err == nil {
+				return numberAs(f, as)
+			}
+			return nil
+		}
+		if text := bytes.TrimRight(v, "\x00"); printable(text) {
+			return string(text)
+		}
+		return hexString(v)
+	case string:
+		if kind == gosnmp.ObjectIdentifier {
+			v = strings.TrimPrefix(v, ".")
+		}
+		switch as {
+		case "int", "uint", "float":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return numberAs(f, as)
+			}
+			return nil
+		}
+		return v
+	case float32:
+		return numberAs(float64(v), as)
+	case float64:
+		return numberAs(v, as)
+	case bool:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// numericValue is the value as a float, if it's a number (or bool)
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func numberAs(f float64, as string) interface{} {
+	switch as {
+	case "int":
+		return int64(f)
+	case "uint":
+		return uint64(f)
+	case "string", "hex":
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
+
+// convert applies the conversion for the column to the value
+func (g *mibGroup) convert(val *pduValue) {
+	val.value = convertValue(val.kind, val.value, g.mib.convert[val.name])
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	gotest "testing" // as 'testing' is the flag
+
+	"github.com/soniah/gosnmp"
+)
+
+func TestConvertValue(t *gotest.T) {
+	tests := []struct {
+		kind gosnmp.Asn1BER
+		v    interface{}
+		as   string
+		want interface{}
+	}{
+		{gosnmp.Integer, 7, "", int64(7)},
+		{gosnmp.Integer, -7, "", int64(-7)},
+		{gosnmp.Counter32, uint(5), "", int64(5)},
+		{gosnmp.Gauge32, uint(4294967295), "", int64(4294967295)},
+		{gosnmp.Counter64, uint64(1 << 63), "", uint64(1 << 63)},
+		{gosnmp.TimeTicks, uint32(250), "", 2.5},
+		{gosnmp.TimeTicks, uint32(250), "ticks", int64(250)},
+		{gosnmp.Integer, 7, "float", 7.0},
+		{gosnmp.Counter32, uint(7), "uint", uint64(7)},
+		{gosnmp.Integer, 7, "string", "7"},
+		{gosnmp.OctetString, []byte("eth0"), "", "eth0"},
+		{gosnmp.OctetString, []byte("eth0\x00"), "", "eth0"},
+		{gosnmp.OctetString, []byte{0, 0x1b, 0xff}, "", "00:1b:ff"},
+		{gosnmp.OctetString, []byte("ab"), "hex", "61:62"},
+		{gosnmp.OctetString, []byte{0xff, 0, 0}, "string", "\xff"},
+		{gosnmp.OctetString, []byte(" 12.5\x00"), "float", 12.5},
+		{gosnmp.OctetString, []byte("12.5"), "int", int64(12)},
+		{gosnmp.OctetString, []byte("n/a"), "int", nil},
+		{gosnmp.ObjectIdentifier, ".1.3.6", "", "1.3.6"},
+		{gosnmp.IPAddress, "192.168.0.1", "", "192.168.0.1"},
+		{gosnmp.OpaqueFloat, float32(1.5), "", 1.5},
+		{gosnmp.OpaqueDouble, 2.25, "int", int64(2)},
+		{gosnmp.Null, nil, "", nil},
+		{gosnmp.NoSuchObject, nil, "", nil},
+		{gosnmp.NoSuchInstance, nil, "", nil},
+		{gosnmp.EndOfMibView, nil, "", nil},
+	}
+	for _, tt := range tests {
+		if got := convertValue(tt.kind, tt.v, tt.as); got != tt.want {
+			t.Errorf("%v %#v as %q: got %#v, want %#v", tt.kind, tt.v, tt.as, got, tt.want)
+		}
+	}
+}
+
+func TestNumberAs(t *gotest.T) {
+	tests := []struct {
+		f    float64
+		as   string
+		want interface{}
+	}{
+		{2.75, "", 2.75},
+		{2.75, "float", 2.75},
+		{2.75, "int", int64(2)},
+		{-2.75, "int", int64(-2)},
+		{2.75, "uint", uint64(2)},
+		{2.75, "string", "2.75"},
+		{1e21, "hex", "1e+21"},
+	}
+	for _, tt := range tests {
+		if got := numberAs(tt.f, tt.as); got != tt.want {
+			t.Errorf("%v as %q: got %#v, want %#v", tt.f, tt.as, got, tt.want)
+		}
+	}
+}
+
+func TestParseConversions(t *gotest.T) {
+	tests := []struct {
+		list []string
+		want map[string]string
+		err  bool
+	}{
+		{list: []string{"ifPhysAddress:hex", "hrSWRunName:string"},
+			want: map[string]string{"ifPhysAddress": "hex", "hrSWRunName": "string"}},
+		{list: []string{"ifPhysAddress"}, err: true},
+		{list: []string{"ifPhysAddress:binary"}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseConversions(tt.list)
+		if (err != nil) != tt.err {
+			t.Errorf("%v: error %v", tt.list, err)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%v: %s is %q, want %q", tt.list, k, got[k], v)
+			}
+		}
+	}
+}
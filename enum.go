@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/soniah/gosnmp"
+)
+
+// loadEnums looks up the MIB labels for the columns that are to be decoded
+func (g *mibGroup) loadEnums() error {
+	g.enums = make(map[string]map[int]string)
+	g.bits = make(map[string]map[int]string)
+	for _, col := range g.mib.Enum {
+		n := mibObject(col)
+		if n == nil || len(n.Enums) == 0 {
+			return fmt.Errorf("no enumeration known for: %s", col)
+		}
+		g.enums[col] = n.Enums
+	}
+	for _, col := range g.mib.Bits {
+		n := mibObject(col)
+		if n == nil || len(n.Bits) == 0 {
+			return fmt.Errorf("no bits known for: %s", col)
+		}
+		g.bits[col] = n.Bits
+	}
+	return nil
+}
+
+// decode adds a 'label' field for enumerated values, and expands
+// BITS into a boolean field per bit in place of the raw value
+func (g *mibGroup) decode(val *pduValue) {
+	if enums, ok := g.enums[val.name]; ok {
+		switch val.kind {
+		case gosnmp.Integer, gosnmp.Uinteger32, gosnmp.Gauge32:
+			n := int(gosnmp.ToBigInt(val.value).Int64())
+			if label, ok := enums[n]; ok {
+				val.addField("label", label)
+			}
+		}
+	}
+	if bits, ok := g.bits[val.name]; ok {
+		octets, ok := val.value.([]byte)
+		if !ok {
+			return
+		}
+		// bit 0 is the most significant bit of the first octet
+		for bit, label := range bits {
+			set := false
+			if bit/8 < len(octets) {
+				set = octets[bit/8]&(0x80>>uint(bit%8)) != 0
+			}
+			val.addField(label, set)
+		}
+		val.value = nil
+	}
+}
+
+func (val *pduValue) addField(name string, v interface{}) {
+	if val.fields == nil {
+		val.fields = make(map[string]interface{})
+	}
+	val.fields[name] = v
+}
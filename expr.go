@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small arithmetic language for transforming values, e.g.,
+// 'value * 8 / 1e6' or 'value / ifSpeed * 100', where 'value' is
+// the value being transformed and other names are the values of
+// columns in the same row
+
+// expr is a parsed expression
+type expr interface {
+	eval(vars func(string) (float64, bool)) (float64, bool)
+}
+
+type exprNum float64
+
+type exprVar string
+
+type exprNeg struct {
+	x expr
+}
+
+type exprOp struct {
+	op   byte
+	x, y expr
+}
+
+func (e exprNum) eval(vars func(string) (float64, bool)) (float64, bool) {
+	return float64(e), true
+}
+
+func (e exprVar) eval(vars func(string) (float64, bool)) (float64, bool) {
+	return vars(string(e))
+}
+
+func (e exprNeg) eval(vars func(string) (float64, bool)) (float64, bool) {
+	x, ok := e.x.eval(vars)
+	return -x, ok
+}
+
+func (e exprOp) eval(vars func(string) (float64, bool)) (float64, bool) {
+	x, ok := e.x.eval(vars)
+	if !ok {
+		return 0, false
+	}
+	y, ok := e.y.eval(vars)
+	if !ok {
+		return 0, false
+	}
+	var v float64
+	switch e.op {
+	case '+':
+		v = x + y
+	case '-':
+		v = x - y
+	case '*':
+		v = x * y
+	case '/':
+		v = x / y
+	case '%':
+		v = math.Mod(x, y)
+	}
+	// influx can't store them
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+// exprParser is a recursive descent parser for expressions
+type exprParser struct {
+	tokens []string
+	pos    int
+	names  []string // of the variables used
+}
+
+func exprTokens(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/%()", c):
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' ||
+				s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character: %c", c)
+		}
+	}
+	return tokens, nil
+}
+
+// parseExpr parses the expression, returning the variables it uses
+func parseExpr(s string) (expr, []string, error) {
+	tokens, err := exprTokens(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.sum()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected: %s", p.tokens[p.pos])
+	}
+	return e, p.names, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// sum := product { ('+' | '-') product }
+func (p *exprParser) sum() (expr, error) {
+	x, err := p.product()
+	for err == nil && (p.peek() == "+" || p.peek() == "-") {
+		op := p.next()[0]
+		var y expr
+		if y, err = p.product(); err == nil {
+			x = exprOp{op, x, y}
+		}
+	}
+	return x, err
+}
+
+// product := unary { ('*' | '/' | '%') unary }
+func (p *exprParser) product() (expr, error) {
+	x, err := p.unary()
+	for err == nil && (p.peek() == "*" || p.peek() == "/" || p.peek() == "%") {
+		op := p.next()[0]
+		var y expr
+		if y, err = p.unary(); err == nil {
+			x = exprOp{op, x, y}
+		}
+	}
+	return x, err
+}
+
+// unary := '-' unary | '(' sum ')' | number | name
+func (p *exprParser) unary() (expr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "-":
+		x, err := p.unary()
+		return exprNeg{x}, err
+	case t == "(":
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return x, nil
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", t)
+		}
+		return exprNum(f), nil
+	case unicode.IsLetter(rune(t[0])) || t[0] == '_':
+		p.names = append(p.names, t)
+		return exprVar(t), nil
+	}
+	return nil, fmt.Errorf("unexpected: %s", t)
+}
+
+// parseTransforms validates the 'transform = column:expression' entries,
+// which can only refer to columns polled by the same mib config
+func parseTransforms(list, columns []string) (map[string]expr, error) {
+	known := map[string]bool{"value": true}
+	for _, col := range columns {
+		known[col] = true
+	}
+	transforms := make(map[string]expr)
+	for _, t := range list {
+		i := strings.Index(t, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid transform: %s", t)
+		}
+		col := strings.TrimSpace(t[:i])
+		if !known[col] || col == "value" {
+			return nil, fmt.Errorf("transform for column not polled: %s", col)
+		}
+		e, names, err := parseExpr(t[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform %s: %s", t, err)
+		}
+		for _, name := range names {
+			if !known[name] {
+				return nil, fmt.Errorf("transform %s refers to column not polled: %s", t, name)
+			}
+		}
+		transforms[col] = e
+	}
+	return transforms, nil
+}
+
+// transform applies the expressions to the values, and any rate of them,
+// referring to the other columns of each row as they were polled.
+// Values that can't be transformed are dropped, as writing them as they
+// are would change the type of the field
+func (g *mibGroup) transform(vals []*pduValue) {
+	if len(g.mib.transforms) == 0 {
+		return
+	}
+	rows := make(map[string]map[string]interface{}) // by instance
+	for _, val := range vals {
+		row, ok := rows[val.suffix]
+		if !ok {
+			row = make(map[string]interface{})
+			rows[val.suffix] = row
+		}
+		row[val.name] = val.value
+	}
+	for _, val := range vals {
+		e, ok := g.mib.transforms[val.name]
+		if !ok {
+			continue
+		}
+		row := rows[val.suffix]
+		apply := func(v interface{}) interface{} {
+			value, ok := numericValue(v)
+			if !ok {
+				log.Printf("transform of %s for %s: not a number: %v\n", val.name, val.column, v)
+				return nil
+			}
+			result, ok := e.eval(func(name string) (float64, bool) {
+				if name == "value" {
+					return value, true
+				}
+				return numericValue(row[name])
+			})
+			if !ok {
+				log.Printf("transform of %s for %s failed\n", val.name, val.column)
+				return nil
+			}
+			return result
+		}
+		if val.value != nil {
+			val.value = apply(val.value)
+		}
+		if rate, ok := val.fields["rate"]; ok {
+			if v := apply(rate); v != nil {
+				val.fields["rate"] = v
+			} else {
+				delete(val.fields, "rate")
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	gotest "testing" // as 'testing' is the flag
+)
+
+func TestParseExpr(t *gotest.T) {
+	vars := map[string]float64{"value": 1e6, "ifSpeed": 1e9, "zero": 0}
+	lookup := func(name string) (float64, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		expr  string
+		want  float64
+		ok    bool // evaluates
+		names []string
+		err   bool // doesn't parse
+	}{
+		{expr: "1 + 2 * 3", want: 7, ok: true},
+		{expr: "(1 + 2) * 3", want: 9, ok: true},
+		{expr: "10 - 4 - 3", want: 3, ok: true},
+		{expr: "12 / 3 / 2", want: 2, ok: true},
+		{expr: "10 % 4 * 2", want: 4, ok: true},
+		{expr: "-2 * 3", want: -6, ok: true},
+		{expr: "- -2", want: 2, ok: true},
+		{expr: "2 * -(1 + 2)", want: -6, ok: true},
+		{expr: "1e3 / 2", want: 500, ok: true},
+		{expr: "2.5e-1 * 4", want: 1, ok: true},
+		{expr: "1E+2", want: 100, ok: true},
+		{expr: ".5 + .5", want: 1, ok: true},
+		{expr: "value * 8 / 1e6", want: 8, ok: true, names: []string{"value"}},
+		{expr: "value / ifSpeed * 100", want: 0.1, ok: true, names: []string{"value", "ifSpeed"}},
+		{expr: "value / zero", names: []string{"value", "zero"}},
+		{expr: "value % zero", names: []string{"value", "zero"}},
+		{expr: "unknown + 1", names: []string{"unknown"}},
+		{expr: "", err: true},
+		{expr: "1 +", err: true},
+		{expr: "(1 + 2", err: true},
+		{expr: "1 + 2)", err: true},
+		{expr: "2 $ 3", err: true},
+		{expr: "1..2", err: true},
+		{expr: "1e", err: true},
+		{expr: "2 3", err: true},
+		{expr: "* 2", err: true},
+	}
+	for _, tt := range tests {
+		e, names, err := parseExpr(tt.expr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%q: names %v, want %v", tt.expr, names, tt.names)
+		}
+		got, ok := e.eval(lookup)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%q: got %v %v, want %v %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseTransforms(t *gotest.T) {
+	columns := []string{"ifHCInOctets", "ifSpeed"}
+	tests := []struct {
+		list []string
+		cols []string // transformed
+		err  bool
+	}{
+		{list: []string{"ifHCInOctets:value * 8 / 1e6"}, cols: []string{"ifHCInOctets"}},
+		{list: []string{" ifHCInOctets : value / ifSpeed * 100", "ifSpeed:value / 1e6"},
+			cols: []string{"ifHCInOctets", "ifSpeed"}},
+		{list: []string{"ifHCInOctets"}, err: true},
+		{list: []string{":value * 8"}, err: true},
+		{list: []string{"ifHCOutOctets:value * 8"}, err: true},
+		{list: []string{"value:value * 8"}, err: true},
+		{list: []string{"ifHCInOctets:value / ifHighSpeed"}, err: true},
+		{list: []string{"ifHCInOctets:value *"}, err: true},
+	}
+	for _, tt := range tests {
+		transforms, err := parseTransforms(tt.list, columns)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: no error", tt.list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", tt.list, err)
+			continue
+		}
+		if len(transforms) != len(tt.cols) {
+			t.Errorf("%v: got %d transforms, want %d", tt.list, len(transforms), len(tt.cols))
+		}
+		for _, col := range tt.cols {
+			if transforms[col] == nil {
+				t.Errorf("%v: no transform for %s", tt.list, col)
+			}
+		}
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/soniah/gosnmp"
+)
+
+// mibGroup is a set of columns that are polled together,
+// as given by a [mibs] section
+type mibGroup struct {
+	name    string // of the mibs section
+	mib     *MibConfig
+	freq    time.Duration
+	next    time.Time         // when it is next to be polled
+	asName  map[string]string // row instance by name
+	asOID   map[string]string // row name by instance
+	labels  map[string]string // from the portfile, by row name
+	bases   []string          // oids of the columns
+	oids    []string
+	parts   []indexPart // of the table index, tagged if known
+	enums   map[string]map[int]string
+	bits    map[string]map[int]string
+	tags    map[string]map[string]string // by row
+	missing []string                     // portfile entries not found
+	seen    map[string]bool              // labelled rows of the last poll
+	noSpeed map[string]bool              // rows logged as having no speed
+}
+
+// tagColumn is a column whose value is used to tag the rows of a table
+type tagColumn struct {
+	column, tag string
+}
+
+// parseTagColumns validates the 'tagcolumn = column[:tag]' entries
+func parseTagColumns(list []string) ([]tagColumn, error) {
+	cols := make([]tagColumn, 0, len(list))
+	for _, s := range list {
+		t := tagColumn{column: s, tag: s}
+		if i := strings.Index(s, ":"); i >= 0 {
+			t.column, t.tag = s[:i], s[i+1:]
+		}
+		if len(t.column) == 0 || len(t.tag) == 0 {
+			return nil, fmt.Errorf("invalid tag column: %s", s)
+		}
+		if err := reservedTag(t.tag); err != nil {
+			return nil, err
+		}
+		cols = append(cols, t)
+	}
+	return cols, nil
+}
+
+// groups returns the mib configs used by the device
+func (conf *Config) groups(c *SnmpConfig) ([]*mibGroup, error) {
+	names := c.Mib
+	if len(names) == 0 {
+		// default is to use the name of snmp config
+		names = []string{c.name}
+		if _, ok := conf.Mibs[c.name]; !ok {
+			names = []string{"*"}
+		}
+	}
+	groups := make([]*mibGroup, 0, len(names))
+	for _, name := range names {
+		m, ok := conf.Mibs[name]
+		if !ok {
+			return nil, fmt.Errorf("no mib config: %s for snmp device: %s", name, c.name)
+		}
+		freq := c.Freq
+		if m.Freq > 0 {
+			freq = m.Freq
+		}
+		groups = append(groups, &mibGroup{
+			name: name,
+			mib:  m,
+			freq: time.Duration(freq) * time.Second,
+		})
+	}
+	return groups, nil
+}
+
+// Mibs lists the mib configs polled, for the status page
+func (c *SnmpConfig) Mibs() string {
+	names := make([]string, 0, len(c.groups))
+	for _, g := range c.groups {
+		names = append(names, g.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// sameGroups reports if the devices poll the same things
+func sameGroups(a, b []*mibGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || !sameSettings(a[i].mib, b[i].mib) {
+			return false
+		}
+	}
+	return true
+}
+
+// label is the column tag for scalers
+func (g *mibGroup) label() string {
+	if len(g.mib.Name) > 0 {
+		return g.mib.Name
+	}
+	if g.name == "*" {
+		return "default"
+	}
+	return g.name
+}
+
+// indexOid is the column whose values name the rows of the table
+func (g *mibGroup) indexOid() (string, error) {
+	if len(g.mib.Index) == 0 {
+		return nameOid, nil
+	}
+	oid, ok := oidFor(g.mib.Index)
+	if !ok {
+		return "", fmt.Errorf("no oid for index: %s", g.mib.Index)
+	}
+	return oid, nil
+}
+
+// named reports if only the rows in the portfile are polled,
+// which applies to tables indexed by interface name
+func (g *mibGroup) named(c *SnmpConfig) bool {
+	if g.mib.Scalers || len(c.PortFile) == 0 {
+		return false
+	}
+	return len(g.mib.Index) == 0 || g.mib.Index == "ifName"
+}
+
+// instance returns the part of the pdu oid after that of the column
+func instance(pdu gosnmp.SnmpPDU, base string) (string, bool) {
+	name := strings.TrimPrefix(pdu.Name, ".")
+	if !strings.HasPrefix(name, base+".") {
+		return "", false
+	}
+	return name[len(base)+1:], true
+}
+
+// translate loads [instance]name for the rows of the table
+func (g *mibGroup) translate(client *gosnmp.GoSNMP, c *SnmpConfig) error {
+	g.asName = make(map[string]string)
+	g.asOID = make(map[string]string)
+	g.labels = make(map[string]string)
+	g.tags = make(map[string]map[string]string)
+	if g.mib.Scalers {
+		return nil
+	}
+	index, err := g.indexOid()
+	if err != nil {
+		return err
+	}
+	pdus, err := walkAll(client, index)
+	if err != nil {
+		return fmt.Errorf("SNMP walk error: %s", err)
+	}
+	named := g.named(c)
+	for _, pdu := range pdus {
+		suffix, ok := instance(pdu, index)
+		v := convertValue(pdu.Type, pdu.Value, "")
+		if !ok || v == nil {
+			continue
+		}
+		name := fmt.Sprint(v)
+		if len(name) == 0 {
+			continue
+		}
+		if named {
+			p, ok := c.portFor(name)
+			if !ok {
+				continue
+			}
+			g.labels[name] = p.label
+			for k, v := range p.tags {
+				g.addTag(suffix, k, v)
+			}
+		}
+		g.asName[name] = suffix
+		g.asOID[suffix] = name
+	}
+	g.missing = nil
+	if named {
+		// make sure we got everything, carrying on with what there is
+		for k := range c.ports {
+			if _, ok := c.portFor(k); !ok {
+				continue // excluded
+			}
+			if _, ok := g.asName[k]; !ok {
+				g.missing = append(g.missing, k)
+			}
+		}
+		if len(g.missing) > 0 {
+			sort.Strings(g.missing)
+			errLog("SNMP (%s) no OID found for: %s\n", c.Host, strings.Join(g.missing, ", "))
+		}
+	}
+	g.indexTags()
+	return g.loadTags(client)
+}
+
+// indexTags tags each row with the components of its index, if the
+// index is configured or is one of several parts known from the MIBs
+func (g *mibGroup) indexTags() {
+	g.parts = g.mib.parts
+	if len(g.parts) == 0 && len(g.mib.Columns) > 0 {
+		if parts := mibIndex(g.mib.Columns[0]); len(parts) > 1 {
+			g.parts = parts
+		}
+	}
+	if len(g.parts) == 0 {
+		return
+	}
+	for suffix := range g.asOID {
+		values, err := splitIndex(g.parts, suffix)
+		if err != nil {
+			log.Printf("mib config %s: %s\n", g.name, err)
+			continue
+		}
+		for tag, value := range values {
+			g.addTag(suffix, tag, value)
+		}
+	}
+}
+
+func (g *mibGroup) addTag(suffix, tag, value string) {
+	if len(value) == 0 {
+		return // influx doesn't allow empty tags
+	}
+	if g.tags[suffix] == nil {
+		g.tags[suffix] = make(map[string]string)
+	}
+	g.tags[suffix][tag] = value
+}
+
+// loadTags looks up the tag column values for each row
+func (g *mibGroup) loadTags(client *gosnmp.GoSNMP) error {
+	for _, t := range g.mib.tagCols {
+		oid, ok := oidFor(t.column)
+		if !ok {
+			return fmt.Errorf("no oid for tag column: %s", t.column)
+		}
+		pdus, err := walkAll(client, oid)
+		if err != nil {
+			return fmt.Errorf("SNMP walk error: %s", err)
+		}
+		var enums map[int]string
+		if n := mibObject(t.column); n != nil {
+			enums = n.Enums
+		}
+		for _, pdu := range pdus {
+			suffix, _ := instance(pdu, oid)
+			if _, ok := g.asOID[suffix]; !ok {
+				continue // not a row being polled
+			}
+			v := convertValue(pdu.Type, pdu.Value, "")
+			if v == nil {
+				continue
+			}
+			value := fmt.Sprint(v)
+			if n, ok := toBig(pdu.Value); ok && enums != nil {
+				if label, ok := enums[int(n.Int64())]; ok {
+					value = label
+				}
+			}
+			g.addTag(suffix, t.tag, value)
+		}
+	}
+	return nil
+}
+
+// setOids works out what is to be requested each poll
+func (g *mibGroup) setOids(c *SnmpConfig) error {
+	g.oids = []string{}
+	g.bases = []string{}
+	named := g.named(c)
+	for _, col := range g.mib.Columns {
+		base, ok := oidFor(col)
+		if !ok {
+			return fmt.Errorf("no oid for col: %s", col)
+		}
+		g.bases = append(g.bases, base)
+		switch {
+		case named:
+			// just named columns
+			for k := range g.asOID {
+				g.oids = append(g.oids, base+"."+k)
+			}
+		case g.mib.Scalers:
+			// or plain old scaler instances
+			g.oids = append(g.oids, base+".0")
+		default:
+			g.oids = append(g.oids, base)
+		}
+	}
+	if len(g.mib.Columns) > 0 {
+		spew("COLUMNS", g.name, g.mib.Columns)
+		spew(g.oids)
+	}
+	return nil
+}
+
+// value maps a returned pdu to its measurement and column
+func (g *mibGroup) value(c *SnmpConfig, pdu gosnmp.SnmpPDU) *pduValue {
+	var root, suffix string
+	for _, base := range g.bases {
+		if s, ok := instance(pdu, base); ok {
+			root, suffix = base, s
+			break
+		}
+	}
+	var col string
+	switch {
+	case g.mib.Scalers:
+		col = g.label()
+	case g.named(c):
+		col = g.labels[g.asOID[suffix]]
+		if pdu.Type == gosnmp.NoSuchInstance || pdu.Type == gosnmp.NoSuchObject {
+			// the row it was looked up as has gone
+			log.Println("no such instance for:", col)
+			c.stale = true
+			return nil
+		}
+	default:
+		col = g.asOID[suffix]
+	}
+	name, ok := oidName(root)
+	if verbose {
+		log.Println("ROOT:", root, "SUFFIX:", suffix, "COL:", col, "NAME:", name, "VALUE:", pdu.Value)
+	}
+	if !ok {
+		log.Printf("Invalid oid: %s\n", pdu.Name)
+		return nil
+	}
+	if len(col) == 0 {
+		spew("empty col for:", suffix)
+		return nil // e.g., a row added since the lookup
+	}
+	if g.seen != nil && !g.mib.Scalers {
+		g.seen[suffix] = true
+	}
+	return &pduValue{name: name, column: col, suffix: suffix, oid: strings.TrimPrefix(pdu.Name, "."),
+		value: pdu.Value, kind: pdu.Type, tags: g.tags[suffix]}
+}
+
+// rows can't be looked up again more often than this because of errors,
+// as some are expected, e.g., interfaces without a name
+const reindexHoldoff = 5 * time.Minute
+
+// reindex looks up the rows of the tables again if the device has
+// rebooted, the rows no longer match what's polled, or it's time to.
+// All the groups are updated together, or not at all
+func (c *SnmpConfig) reindex(client *gosnmp.GoSNMP, now time.Time) {
+	since := now.Sub(c.indexed)
+	switch {
+	case c.retry > 0:
+		if now.Before(c.retryAt) {
+			return
+		}
+	case c.rebooted:
+	case c.stale && since >= reindexHoldoff:
+	case c.Reindex > 0 && since >= time.Duration(c.Reindex)*time.Minute:
+	default:
+		return
+	}
+	log.Println("looking up rows again for:", c.Host)
+	c.rebooted, c.stale = false, false
+	fresh := make([]*mibGroup, len(c.groups))
+	for i, g := range c.groups {
+		n := *g
+		err := n.translate(client, c)
+		if err == nil {
+			err = n.setOids(c)
+		}
+		if err != nil {
+			errLog("SNMP (%s) reindex error: %s\n", c.Host, err)
+			c.backoff(now)
+			c.setProblem(err)
+			return
+		}
+		fresh[i] = &n
+	}
+	if err := c.loadSysTags(client); err != nil {
+		errLog("SNMP (%s) reindex error: %s\n", c.Host, err)
+		c.backoff(now)
+		c.setProblem(err)
+		return
+	}
+	for i, g := range c.groups {
+		g.swap(fresh[i])
+	}
+	c.indexed = now
+	if len(c.missingPorts()) > 0 {
+		// they may turn up, e.g., once a line card is back, so keep
+		// looking but less often each time they don't
+		c.backoff(now)
+		return
+	}
+	c.retry = 0
+}
+
+// the longest to wait before trying to look up the rows again
+const maxRetry = 30 * time.Minute
+
+// backoff doubles the time until the rows are looked up again
+func (c *SnmpConfig) backoff(now time.Time) {
+	c.retry *= 2
+	if c.retry == 0 {
+		c.retry = time.Duration(c.Freq) * time.Second
+	}
+	if c.retry > maxRetry {
+		c.retry = maxRetry
+	}
+	c.retryAt = now.Add(c.retry)
+}
+
+func (c *SnmpConfig) missingPorts() []string {
+	var missing []string
+	for _, g := range c.groups {
+		missing = append(missing, g.missing...)
+	}
+	return missing
+}
+
+// setProblem records why the device can't be polled as it should be
+func (c *SnmpConfig) setProblem(err error) {
+	missing := c.missingPorts()
+	c.health.Lock()
+	defer c.health.Unlock()
+	c.problem = ""
+	if err != nil {
+		c.problem = err.Error()
+	}
+	c.missing = missing
+}
+
+// Health describes the state of the device for the status page
+func (c *SnmpConfig) Health() string {
+	c.health.Lock()
+	defer c.health.Unlock()
+	var problems []string
+	if len(c.problem) > 0 {
+		problems = append(problems, c.problem)
+	}
+	if len(c.missing) > 0 {
+		problems = append(problems, "not found: "+strings.Join(c.missing, ", "))
+	}
+	if len(problems) == 0 {
+		return "ok"
+	}
+	return "degraded - " + strings.Join(problems, "; ")
+}
+
+// swap takes the row lookups from a freshly translated copy
+func (g *mibGroup) swap(n *mibGroup) {
+	g.asName, g.asOID, g.labels = n.asName, n.asOID, n.labels
+	g.tags, g.parts = n.tags, n.parts
+	g.bases, g.oids, g.missing = n.bases, n.oids, n.missing
+	g.seen = nil
+}
+
+// vanished reports if a row returned by the last poll of the table
+// is no longer there, as happens when the device renumbers its rows
+func (g *mibGroup) vanished(last map[string]bool) bool {
+	for suffix := range last {
+		if !g.seen[suffix] {
+			spew("row has gone:", g.asOID[suffix])
+			return true
+		}
+	}
+	return false
+}
+
+// due reports if the group should be polled this cycle, allowing
+// for a bit of slack so the ticker doesn't cause it to skip one
+func (g *mibGroup) due(now time.Time, tick time.Duration) bool {
+	return !now.Add(tick / 2).Before(g.next)
+}
+
+// poll gathers the points for the group
+func (c *SnmpConfig) poll(snmp *gosnmp.GoSNMP, g *mibGroup, now time.Time) ([]client.Point, error) {
+	var vals []*pduValue
+	last := g.seen
+	g.seen = make(map[string]bool)
+	err := c.fetch(snmp, g, func(pdu gosnmp.SnmpPDU) error {
+		val := g.value(c, pdu)
+		if val == nil || val.value == nil {
+			return nil
+		}
+		g.decode(val)
+		c.counterRate(g.name, g.mib.Rate, val, now)
+		g.convert(val)
+		vals = append(vals, val)
+		return nil
+	})
+	if err == nil && g.vanished(last) {
+		c.stale = true
+	}
+	// these can refer to other columns, so the rows must be complete
+	g.utilisation(vals)
+	g.transform(vals)
+	points := make([]client.Point, 0, len(vals))
+	rows := newRowPoints(g, c.Host)
+	for _, val := range vals {
+		pt := makePoint(c.Host, c.Tags(), val, now)
+		if len(pt.Fields) == 0 {
+			continue
+		}
+		g.record(pt, val.kind)
+		if g.mib.Layout == "row" {
+			rows.add(val, pt)
+			continue
+		}
+		if n := g.mib.naming; n != nil {
+			data := g.nameData(c.Host, val.name, val.column)
+			pt.Measurement = n.name(n.measurement, data, val.name)
+			if n.field != nil {
+				pt.Fields = n.fields(pt.Fields, n.name(n.field, data, "value"))
+			}
+			n.tags(&pt)
+		}
+		points = append(points, pt)
+	}
+	return append(points, rows.points()...), err
+}
+
+// fetch walks the columns of the table, or gets the instances wanted
+func (c *SnmpConfig) fetch(snmp *gosnmp.GoSNMP, g *mibGroup, fn gosnmp.WalkFunc) error {
+	if !g.mib.Scalers && !g.named(c) {
+		for _, oid := range g.oids {
+			c.incRequests()
+			if err := walk(snmp, oid, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// we can only get 'maxOids' worth of snmp requests at a time
+	for i := 0; i < len(g.oids); i += maxOids {
+		end := i + maxOids
+		if end > len(g.oids) {
+			end = len(g.oids)
+		}
+		c.incRequests()
+		pkt, err := snmp.Get(g.oids[i:end])
+		if err != nil {
+			return err
+		}
+		c.incGets()
+		if verbose {
+			log.Println("SNMP GET CNT:", len(pkt.Variables))
+		}
+		for _, pdu := range pkt.Variables {
+			fn(pdu)
+		}
+	}
+	return nil
+}
+
+// nameData is what the naming templates are given for a point
+func (g *mibGroup) nameData(host, column, row string) *nameData {
+	return &nameData{Mib: g.label(), Group: g.name, Column: column, Row: row, Host: host}
+}
+
+// rowPoints merges the columns of each row into a single point
+type rowPoints struct {
+	group *mibGroup
+	host  string
+	rows  map[string]*client.Point // by instance
+	order []string
+}
+
+func newRowPoints(g *mibGroup, host string) *rowPoints {
+	return &rowPoints{group: g, host: host, rows: make(map[string]*client.Point)}
+}
+
+// add the fields of a column, named after it
+func (r *rowPoints) add(val *pduValue, pt client.Point) {
+	n := r.group.mib.naming
+	if n == nil {
+		n = &naming{}
+	}
+	row, ok := r.rows[val.suffix]
+	if !ok {
+		data := r.group.nameData(r.host, "", val.column)
+		row = &client.Point{
+			Measurement: n.name(n.measurement, data, r.group.label()),
+			Tags:        pt.Tags,
+			Fields:      make(map[string]interface{}),
+			Time:        pt.Time,
+		}
+		n.tags(row)
+		r.rows[val.suffix] = row
+		r.order = append(r.order, val.suffix)
+	}
+	base := n.name(n.field, r.group.nameData(r.host, val.name, val.column), val.name)
+	for field, v := range n.fields(pt.Fields, base) {
+		row.Fields[field] = v
+	}
+}
+
+func (r *rowPoints) points() []client.Point {
+	points := make([]client.Point, 0, len(r.order))
+	for _, suffix := range r.order {
+		points = append(points, *r.rows[suffix])
+	}
+	return points
+}
+
+// record keeps the latest value for the metrics page,
+// values expire if the device isn't polled for a few cycles
+func (g *mibGroup) record(pt client.Point, kind gosnmp.Asn1BER) {
+	if httpPort > 0 {
+		metrics.record(pt, kind, 3*g.freq)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// index types that can make up the instance of a table row
+var indexTypes = map[string]bool{
+	"int":      true,
+	"ipaddr":   true,
+	"ipv6addr": true, // fixed 16 octets
+	"inettype": true, // InetAddressType, for the inetaddr after it
+	"inetaddr": true, // InetAddress, length prefixed
+	"mac":      true, // fixed 6 octets
+	"string":   true, // length prefixed
+	"implied":  true, // string taking the rest of the instance
+	"oid":      true, // length prefixed
+}
+
+// indexPart is one component of a table index
+type indexPart struct {
+	name, kind string
+}
+
+// parseIndexParts validates the 'indexpart = name:type' entries
+func parseIndexParts(list []string) ([]indexPart, error) {
+	parts := make([]indexPart, 0, len(list))
+	for i, s := range list {
+		j := strings.LastIndex(s, ":")
+		if j < 1 || !indexTypes[s[j+1:]] {
+			return nil, fmt.Errorf("invalid index part: %s", s)
+		}
+		if s[j+1:] == "implied" && i < len(list)-1 {
+			return nil, fmt.Errorf("implied index part must be last: %s", s)
+		}
+		if err := reservedTag(s[:j]); err != nil {
+			return nil, err
+		}
+		parts = append(parts, indexPart{s[:j], s[j+1:]})
+	}
+	return parts, nil
+}
+
+// mibIndex works out the index of the table the column is in from the MIBs
+func mibIndex(col string) []indexPart {
+	oidLock.RLock()
+	defer oidLock.RUnlock()
+	row := mibs.row(mibs.node(col))
+	if row == nil || len(row.Index) == 0 {
+		return nil
+	}
+	parts := make([]indexPart, 0, len(row.Index))
+	for i, name := range row.Index {
+		n := mibs.node(name)
+		if n == nil {
+			return nil
+		}
+		part := indexPart{name: n.Name, kind: "int"}
+		switch {
+		case n.TC == "MacAddress":
+			part.kind = "mac"
+		case n.TC == "InetAddressType":
+			part.kind = "inettype"
+		case n.TC == "InetAddress" && !(row.Implied && i == len(row.Index)-1):
+			part.kind = "inetaddr"
+		case n.TC == "InetAddressIPv4":
+			part.kind = "ipaddr"
+		case n.TC == "InetAddressIPv6":
+			part.kind = "ipv6addr"
+		case n.Syntax == "IpAddress" || n.Syntax == "NetworkAddress":
+			part.kind = "ipaddr"
+		case n.Syntax == "OCTET STRING" && row.Implied && i == len(row.Index)-1:
+			part.kind = "implied"
+		case n.Syntax == "OCTET STRING":
+			part.kind = "string"
+		case n.Syntax == "OBJECT IDENTIFIER":
+			part.kind = "oid"
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// splitIndex decodes the instance of a row into its index components
+func splitIndex(parts []indexPart, instance string) (map[string]string, error) {
+	var subids []uint64
+	for _, s := range strings.Split(instance, ".") {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid instance: %s", instance)
+		}
+		subids = append(subids, n)
+	}
+	// take removes the next n sub-identifiers
+	take := func(n uint64) ([]uint64, error) {
+		if n > uint64(len(subids)) {
+			return nil, fmt.Errorf("instance too short: %s", instance)
+		}
+		ids := subids[:n]
+		subids = subids[n:]
+		return ids, nil
+	}
+	octets := func(ids []uint64) ([]byte, error) {
+		b := make([]byte, len(ids))
+		for i, id := range ids {
+			if id > 255 {
+				return nil, fmt.Errorf("invalid octet in instance: %s", instance)
+			}
+			b[i] = byte(id)
+		}
+		return b, nil
+	}
+	values := make(map[string]string, len(parts))
+	inetType := uint64(0) // unknown, until given by an inettype part
+	for _, p := range parts {
+		var ids []uint64
+		var err error
+		switch p.kind {
+		case "int", "inettype":
+			ids, err = take(1)
+		case "ipaddr":
+			ids, err = take(4)
+		case "ipv6addr":
+			ids, err = take(16)
+		case "mac":
+			ids, err = take(6)
+		case "implied":
+			ids, err = take(uint64(len(subids)))
+		case "string", "oid", "inetaddr":
+			if ids, err = take(1); err == nil {
+				ids, err = take(ids[0])
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		var value string
+		switch p.kind {
+		case "int":
+			value = strconv.FormatUint(ids[0], 10)
+		case "inettype":
+			inetType = ids[0]
+			value = strconv.FormatUint(ids[0], 10)
+		case "oid":
+			s := make([]string, len(ids))
+			for i, id := range ids {
+				s[i] = strconv.FormatUint(id, 10)
+			}
+			value = strings.Join(s, ".")
+		default:
+			b, err := octets(ids)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case p.kind == "ipaddr" || p.kind == "ipv6addr":
+				value = net.IP(b).String()
+			case p.kind == "inetaddr":
+				value = inetAddress(inetType, b)
+			case p.kind == "mac" || !printable(b):
+				value = hexString(b)
+			default:
+				value = string(b)
+			}
+		}
+		values[p.name] = value
+	}
+	if len(subids) > 0 {
+		return nil, fmt.Errorf("instance too long: %s", instance)
+	}
+	return values, nil
+}
+
+// inetAddress formats an InetAddress (RFC 4001) by its InetAddressType,
+// or by its length if the type isn't known
+func inetAddress(kind uint64, b []byte) string {
+	// the zone index of ipv4z and ipv6z follows the address
+	zone := func(n int) string {
+		return fmt.Sprintf("%s%%%d", net.IP(b[:n]), binary.BigEndian.Uint32(b[n:]))
+	}
+	switch {
+	case (kind == 0 || kind == 1) && len(b) == 4,
+		(kind == 0 || kind == 2) && len(b) == 16:
+		return net.IP(b).String()
+	case kind == 3 && len(b) == 8:
+		return zone(4)
+	case kind == 4 && len(b) == 20:
+		return zone(16)
+	case kind == 16 && printable(b):
+		return string(b) // dns
+	case len(b) == 0:
+		return ""
+	}
+	return hexString(b)
+}
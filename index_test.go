@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	gotest "testing" // as 'testing' is the flag
+)
+
+func TestParseIndexParts(t *gotest.T) {
+	tests := []struct {
+		list []string
+		want []indexPart
+		err  bool
+	}{
+		{list: []string{"ifIndex:int", "ipAddress:ipaddr"},
+			want: []indexPart{{"ifIndex", "int"}, {"ipAddress", "ipaddr"}}},
+		{list: []string{"mac:mac", "name:string", "oid:oid", "rest:implied"},
+			want: []indexPart{{"mac", "mac"}, {"name", "string"}, {"oid", "oid"}, {"rest", "implied"}}},
+		{list: []string{"destType:inettype", "dest:inetaddr", "addr:ipv6addr"},
+			want: []indexPart{{"destType", "inettype"}, {"dest", "inetaddr"}, {"addr", "ipv6addr"}}},
+		{list: []string{"a:b:int"}, want: []indexPart{{"a:b", "int"}}},
+		{list: []string{}, want: []indexPart{}},
+		{list: []string{"ifIndex"}, err: true},
+		{list: []string{":int"}, err: true},
+		{list: []string{"ifIndex:integer"}, err: true},
+		{list: []string{"rest:implied", "ifIndex:int"}, err: true},
+		{list: []string{"host:int"}, err: true},
+		{list: []string{"ifIndex:int", "column:string"}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseIndexParts(tt.list)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: no error", tt.list)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v %v, want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitIndex(t *gotest.T) {
+	tests := []struct {
+		parts    []indexPart
+		instance string
+		want     map[string]string
+		err      bool
+	}{
+		{
+			parts:    []indexPart{{"ifIndex", "int"}, {"ipAddress", "ipaddr"}},
+			instance: "3.10.0.0.1",
+			want:     map[string]string{"ifIndex": "3", "ipAddress": "10.0.0.1"},
+		},
+		{
+			parts:    []indexPart{{"mac", "mac"}},
+			instance: "0.27.33.10.255.1",
+			want:     map[string]string{"mac": "00:1b:21:0a:ff:01"},
+		},
+		{
+			parts:    []indexPart{{"name", "string"}, {"rest", "implied"}},
+			instance: "2.104.105.1.2",
+			want:     map[string]string{"name": "hi", "rest": "01:02"},
+		},
+		{
+			parts:    []indexPart{{"vrf", "implied"}},
+			instance: "114.101.100",
+			want:     map[string]string{"vrf": "red"},
+		},
+		{
+			parts:    []indexPart{{"vrf", "implied"}},
+			instance: "",
+			err:      true,
+		},
+		{
+			parts:    []indexPart{{"oid", "oid"}, {"n", "int"}},
+			instance: "3.1.3.6.7",
+			want:     map[string]string{"oid": "1.3.6", "n": "7"},
+		},
+		{
+			parts:    []indexPart{{"destType", "inettype"}, {"dest", "inetaddr"}, {"pfxLen", "int"}},
+			instance: "1.4.192.168.0.0.16",
+			want:     map[string]string{"destType": "1", "dest": "192.168.0.0", "pfxLen": "16"},
+		},
+		{
+			parts:    []indexPart{{"destType", "inettype"}, {"dest", "inetaddr"}},
+			instance: "2.16.32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.1",
+			want:     map[string]string{"destType": "2", "dest": "2001:db8::1"},
+		},
+		{
+			parts:    []indexPart{{"destType", "inettype"}, {"dest", "inetaddr"}},
+			instance: "3.8.10.0.0.1.0.0.0.5",
+			want:     map[string]string{"destType": "3", "dest": "10.0.0.1%5"},
+		},
+		{
+			parts:    []indexPart{{"destType", "inettype"}, {"dest", "inetaddr"}},
+			instance: "16.3.97.98.99",
+			want:     map[string]string{"destType": "16", "dest": "abc"},
+		},
+		{
+			// without the type it's known by its length
+			parts:    []indexPart{{"dest", "inetaddr"}},
+			instance: "4.10.0.0.1",
+			want:     map[string]string{"dest": "10.0.0.1"},
+		},
+		{
+			parts:    []indexPart{{"destType", "inettype"}, {"dest", "inetaddr"}},
+			instance: "1.3.10.0.0",
+			want:     map[string]string{"destType": "1", "dest": "0a:00:00"},
+		},
+		{
+			parts:    []indexPart{{"addr", "ipv6addr"}},
+			instance: "254.128.0.0.0.0.0.0.2.0.0.255.254.0.0.1",
+			want:     map[string]string{"addr": "fe80::200:ff:fe00:1"},
+		},
+		{
+			parts:    []indexPart{{"ifIndex", "int"}, {"ipAddress", "ipaddr"}},
+			instance: "3.10.0.0",
+			err:      true,
+		},
+		{
+			parts:    []indexPart{{"dest", "inetaddr"}},
+			instance: "4.10.0.0",
+			err:      true,
+		},
+		{
+			parts:    []indexPart{{"ifIndex", "int"}},
+			instance: "3.4",
+			err:      true,
+		},
+		{
+			parts:    []indexPart{{"name", "string"}},
+			instance: "5.104.105",
+			err:      true,
+		},
+		{
+			parts:    []indexPart{{"ipAddress", "ipaddr"}},
+			instance: "10.0.0.256",
+			err:      true,
+		},
+		{
+			parts:    []indexPart{{"ifIndex", "int"}},
+			instance: "x",
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := splitIndex(tt.parts, tt.instance)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v %s: no error, got %v", tt.parts, tt.instance, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v %s: got %v %v, want %v", tt.parts, tt.instance, got, err, tt.want)
+		}
+	}
+}
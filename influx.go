@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdb/influxdb/client"
@@ -21,22 +24,40 @@ func (cfg *InfluxConfig) BP() *client.BatchPoints {
 	}
 }
 
-func makePoint(host string, val *pduValue, when time.Time) client.Point {
+func makePoint(host string, device map[string]string, val *pduValue, when time.Time) client.Point {
+	fields := make(map[string]interface{})
+	if val.value != nil {
+		fields["value"] = val.value
+	}
+	for k, v := range val.fields {
+		fields[k] = v
+	}
+	tags := make(map[string]string, len(device)+len(val.tags)+2)
+	for k, v := range device {
+		tags[k] = v
+	}
+	for k, v := range val.tags {
+		tags[k] = v
+	}
+	tags["host"] = host
+	tags["column"] = val.column
 	return client.Point{
 		Measurement: val.name,
-		Tags: map[string]string{
-			"host":   host,
-			"column": val.column,
-		},
-		Fields: map[string]interface{}{
-			"value": val.value,
-		},
-		Time: when,
+		Tags:        tags,
+		Fields:      fields,
+		Time:        when,
 	}
 }
 
 func (cfg *InfluxConfig) Connect() error {
-	u, err := url.Parse(fmt.Sprintf("http://%s:%d", cfg.Host, cfg.Port))
+	switch cfg.Version {
+	case "", "1":
+	case "2", "3":
+		return cfg.connectV2()
+	default:
+		return fmt.Errorf("invalid influx version: %s", cfg.Version)
+	}
+	u, err := url.Parse(fmt.Sprintf("%s://%s:%d", cfg.scheme(), cfg.Host, cfg.Port))
 	if err != nil {
 		return err
 	}
@@ -52,41 +73,204 @@ func (cfg *InfluxConfig) Connect() error {
 		return err
 	}
 
+	// writes are made directly, so the status of any failure is known
+	cfg.http = &http.Client{Timeout: 30 * time.Second}
 	_, _, err = cfg.conn.Ping()
 	return err
 }
 
-func (cfg *InfluxConfig) Init() {
+func (cfg *InfluxConfig) scheme() string {
+	if cfg.SSL {
+		return "https"
+	}
+	return "http"
+}
+
+func (cfg *InfluxConfig) Init() error {
+	if cfg.iChan != nil {
+		return nil // already shared by another snmp device
+	}
 	if verbose {
 		log.Println("Connecting to:", cfg.Host)
 	}
-	cfg.iChan = make(chan *client.BatchPoints, 65535)
 	if err := cfg.Connect(); err != nil {
 		log.Println("failed connecting to:", cfg.Host)
-		log.Println("error:", err)
-		log.Fatal(err)
+		return err
 	}
 	if verbose {
 		log.Println("Connected to:", cfg.Host)
 	}
+	return cfg.start()
+}
 
+// start the emitter once connected
+func (cfg *InfluxConfig) start() error {
+	cfg.iChan = make(chan *client.BatchPoints, 65535)
+	cfg.done = make(chan struct{})
+	cfg.stopped = make(chan struct{})
+	var err error
+	if cfg.Spool {
+		if err = cfg.openSpool(); err != nil {
+			err = fmt.Errorf("can't open spool: %s", err)
+		}
+	}
 	go influxEmitter(cfg)
+	return err
 }
 
-func (c *InfluxConfig) Send(bps *client.BatchPoints) {
+func (cfg *InfluxConfig) openSpool() error {
+	name := cfg.name
+	if name == "*" {
+		name = "default"
+	}
+	size := int64(cfg.SpoolMaxSize) << 20
+	if size == 0 {
+		size = 256 << 20
+	}
+	age := time.Duration(cfg.SpoolMaxAge) * time.Hour
+	var err error
+	cfg.spool, err = openSpool(filepath.Join(spoolDir, name), size, age)
+	return err
+}
+
+// Send implements Sink
+func (c *InfluxConfig) Send(points []client.Point) {
+	bps := c.BP()
+	bps.Points = points
+	c.queue(bps)
+}
+
+// Flush implements Sink
+func (c *InfluxConfig) Flush(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := len(c.iChan) + c.Spooled() + int(atomic.LoadInt32(&c.busy))
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("influx %s: %d batches not sent", c.name, pending)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Close implements Sink, batches still in memory are
+// moved to the spool if there is one
+func (c *InfluxConfig) Close() error {
+	if c.done == nil {
+		return nil
+	}
+	c.closing.Do(func() {
+		close(c.done)
+		<-c.stopped
+	})
+	if c.spool == nil {
+		return nil
+	}
+	for {
+		select {
+		case bps := <-c.iChan:
+			if err := c.spool.put(bps); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// Stats implements Sink
+func (c *InfluxConfig) Stats() SinkStats {
+	return SinkStats{
+		Name:    c.name,
+		Target:  c.Hostname() + "/" + c.Database(),
+		Sent:    atomic.LoadInt64(&c.Sent),
+		Errors:  atomic.LoadInt64(&c.Errors),
+		Pending: len(c.iChan) + c.Spooled(),
+		Dropped: c.Dropped(),
+	}
+}
+
+func (c *InfluxConfig) queue(bps *client.BatchPoints) {
+	if c.spool != nil {
+		err := c.spool.put(bps)
+		if err == nil {
+			return
+		}
+		// keep it in memory rather than lose it
+		errMsg("spool write error", err)
+	}
 	c.iChan <- bps
 }
 
+// Spooled is the number of batches on disk waiting to be sent
+func (c *InfluxConfig) Spooled() int {
+	if c.spool == nil {
+		return 0
+	}
+	return c.spool.Pending()
+}
+
+// SpoolBytes is the disk space used by the spool
+func (c *InfluxConfig) SpoolBytes() int64 {
+	if c.spool == nil {
+		return 0
+	}
+	return c.spool.Bytes()
+}
+
+// Dropped is the number of points discarded as the server wouldn't
+// accept them, or due to spool limits
+func (c *InfluxConfig) Dropped() int64 {
+	dropped := atomic.LoadInt64(&c.dropped)
+	if c.spool == nil {
+		return dropped
+	}
+	return dropped + atomic.LoadInt64(&c.spool.Dropped)
+}
+
+func (c *InfluxConfig) write(bps *client.BatchPoints) error {
+	if c.v2() {
+		return c.writeV2(bps)
+	}
+	return c.writeV1(bps)
+}
+
+// Database is the db or bucket being written to
+func (c *InfluxConfig) Database() string {
+	if c.v2() {
+		return c.Bucket
+	}
+	return c.DB
+}
+
 func (c *InfluxConfig) Hostname() string {
 	return strings.Split(c.Host, ":")[0]
 }
 
+const retryDelay = 30 * time.Second
+
 // use chan as a queue so that interupted connections to
 // influxdb server don't drop collected data
 
 func influxEmitter(cfg *InfluxConfig) {
+	defer close(cfg.stopped)
 	for {
+		// spooled batches are sent oldest first
+		if cfg.spool != nil {
+			if f, data, ok := cfg.spool.next(); ok {
+				if !testing && !cfg.deliver(data) {
+					return
+				}
+				cfg.spool.Done(f)
+				continue
+			}
+		}
 		select {
+		case <-cfg.done:
+			return
+		case <-cfg.spool.wait():
 		case data := <-cfg.iChan:
 			if testing {
 				break
@@ -95,21 +279,44 @@ func influxEmitter(cfg *InfluxConfig) {
 				log.Println("null influx input")
 				continue
 			}
-
-			// keep trying until we get it (don't drop the data)
-			for {
-				if _, err := cfg.conn.Write(*data); err != nil {
-					cfg.incErrors()
-					log.Println("influxdb write error:", err)
-					// try again in a bit
-					// TODO: this could be better
-					time.Sleep(30 * time.Second)
-					continue
-				} else {
-					cfg.incSent()
+			atomic.StoreInt32(&cfg.busy, 1)
+			ok := cfg.deliver(data)
+			atomic.StoreInt32(&cfg.busy, 0)
+			if !ok {
+				// put it back so it can be spooled on close
+				select {
+				case cfg.iChan <- data:
+				default:
+					log.Println("influx queue full, dropping batch for:", cfg.Host)
 				}
-				break
+				return
 			}
 		}
 	}
 }
+
+// deliver keeps trying until the batch is written (don't drop the data),
+// only giving up if the emitter is being shut down, or dropping it if
+// the server will never accept it so it doesn't hold up everything else
+func (cfg *InfluxConfig) deliver(data *client.BatchPoints) bool {
+	for {
+		err := cfg.write(data)
+		if err == nil {
+			cfg.incSent()
+			return true
+		}
+		cfg.incErrors()
+		if !retryable(err) {
+			atomic.AddInt64(&cfg.dropped, int64(len(data.Points)))
+			errLog("influxdb (%s) dropped batch of %d points: %s\n", cfg.Host, len(data.Points), err)
+			return true
+		}
+		log.Println("influxdb write error:", err)
+		// try again in a bit
+		select {
+		case <-time.After(retryDelay):
+		case <-cfg.done:
+			return false
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// InfluxDB 2.x and 3.x accept line protocol via the v2 write api,
+// and 1.x via its own write endpoint
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+func (cfg *InfluxConfig) v2() bool {
+	return cfg.Version == "2" || cfg.Version == "3"
+}
+
+func (cfg *InfluxConfig) endpoint(path string) string {
+	return fmt.Sprintf("%s://%s:%d%s", cfg.scheme(), cfg.Host, cfg.Port, path)
+}
+
+func (cfg *InfluxConfig) authorize(req *http.Request) {
+	switch {
+	case len(cfg.Token) > 0:
+		req.Header.Set("Authorization", "Token "+cfg.Token)
+	case len(cfg.User) > 0:
+		req.SetBasicAuth(cfg.User, cfg.Password)
+	}
+}
+
+func (cfg *InfluxConfig) connectV2() error {
+	switch cfg.Precision {
+	case "":
+		cfg.Precision = "ns"
+	case "ns", "us", "ms", "s":
+	default:
+		return fmt.Errorf("invalid precision: %s", cfg.Precision)
+	}
+	if len(cfg.Bucket) == 0 {
+		return fmt.Errorf("no bucket specified for: %s", cfg.Host)
+	}
+	cfg.http = &http.Client{Timeout: 30 * time.Second}
+	// 3.x and cloud require the token for a ping too
+	req, err := http.NewRequest("GET", cfg.endpoint("/ping"), nil)
+	if err != nil {
+		return err
+	}
+	cfg.authorize(req)
+	resp, err := cfg.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ping failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// writeError is a reply to a write other than success, kept so that
+// what is worth retrying can be told from what the server won't accept
+type writeError struct {
+	status int
+	msg    string
+}
+
+func (e *writeError) Error() string {
+	return "write failed: " + e.msg
+}
+
+func (cfg *InfluxConfig) writeV2(bps *client.BatchPoints) error {
+	q := url.Values{}
+	q.Set("org", cfg.Org)
+	q.Set("bucket", cfg.Bucket)
+	q.Set("precision", cfg.Precision)
+	return cfg.post("/api/v2/write?"+q.Encode(), cfg.Precision, bps)
+}
+
+func (cfg *InfluxConfig) writeV1(bps *client.BatchPoints) error {
+	q := url.Values{}
+	q.Set("db", bps.Database)
+	q.Set("rp", bps.RetentionPolicy)
+	return cfg.post("/write?"+q.Encode(), "ns", bps)
+}
+
+// post writes the points as line protocol
+func (cfg *InfluxConfig) post(path, precision string, bps *client.BatchPoints) error {
+	var buf bytes.Buffer
+	for _, pt := range bps.Points {
+		appendLine(&buf, pt, precision)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	req, err := http.NewRequest("POST", cfg.endpoint(path), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	cfg.authorize(req)
+	resp, err := cfg.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return &writeError{resp.StatusCode, strings.TrimSpace(resp.Status + " " + string(body))}
+	}
+	return nil
+}
+
+// retryable reports if a failed write may yet succeed, i.e., the server
+// couldn't be reached, had a problem of its own or asked us to slow down.
+// Anything else it rejects (bad data, auth, no such db) it always will
+func retryable(err error) bool {
+	we, ok := err.(*writeError)
+	if !ok {
+		return true
+	}
+	return we.status/100 == 5 || we.status == http.StatusTooManyRequests
+}
+
+// appendLine writes the point in line protocol, keys are sorted
+// as that is what the server prefers
+func appendLine(buf *bytes.Buffer, pt client.Point, precision string) {
+	fields := make([]string, 0, len(pt.Fields))
+	for k, v := range pt.Fields {
+		if s, ok := fieldValue(v); ok {
+			fields = append(fields, tagEscaper.Replace(k)+"="+s)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	sort.Strings(fields)
+	tags := make([]string, 0, len(pt.Tags))
+	for k, v := range pt.Tags {
+		if len(v) > 0 {
+			tags = append(tags, tagEscaper.Replace(k)+"="+tagEscaper.Replace(v))
+		}
+	}
+	sort.Strings(tags)
+
+	buf.WriteString(measurementEscaper.Replace(pt.Measurement))
+	for _, t := range tags {
+		buf.WriteByte(',')
+		buf.WriteString(t)
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Join(fields, ","))
+	if !pt.Time.IsZero() {
+		var ts int64
+		switch precision {
+		case "s":
+			ts = pt.Time.Unix()
+		case "ms":
+			ts = pt.Time.UnixNano() / int64(time.Millisecond)
+		case "us":
+			ts = pt.Time.UnixNano() / int64(time.Microsecond)
+		default:
+			ts = pt.Time.UnixNano()
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(ts, 10))
+	}
+	buf.WriteByte('\n')
+}
+
+func fieldValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint64:
+		return strconv.FormatUint(v, 10) + "u", true
+	case float32:
+		return floatValue(float64(v))
+	case float64:
+		return floatValue(v)
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`, true
+	case []byte:
+		return `"` + stringEscaper.Replace(string(v)) + `"`, true
+	case nil:
+		return "", false
+	}
+	return `"` + stringEscaper.Replace(fmt.Sprint(v)) + `"`, true
+}
+
+func floatValue(f float64) (string, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	gotest "testing" // as 'testing' is the flag
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+func TestAppendLine(t *gotest.T) {
+	when := time.Unix(1500000000, 123456789)
+	tests := []struct {
+		name      string
+		pt        client.Point
+		precision string
+		want      string
+	}{
+		{
+			name: "plain",
+			pt: client.Point{Measurement: "ifHCInOctets", Tags: map[string]string{"host": "sw1", "column": "ge-0/0/1"},
+				Fields: map[string]interface{}{"value": uint64(42), "rate": 1.5}, Time: when},
+			want: "ifHCInOctets,column=ge-0/0/1,host=sw1 rate=1.5,value=42u 1500000000123456789\n",
+		},
+		{
+			name: "escaped",
+			pt: client.Point{Measurement: "my measure,x", Tags: map[string]string{"a b": "c,d=e"},
+				Fields: map[string]interface{}{"f=1": `say "hi" \ bye`}, Time: when},
+			precision: "s",
+			want:      `my\ measure\,x,a\ b=c\,d\=e f\=1="say \"hi\" \\ bye" 1500000000` + "\n",
+		},
+		{
+			name: "types",
+			pt: client.Point{Measurement: "m",
+				Fields: map[string]interface{}{"i": int64(-3), "b": true, "s": []byte("x")}, Time: when},
+			precision: "ms",
+			want:      `m b=true,i=-3i,s="x" 1500000000123` + "\n",
+		},
+		{
+			name: "empty tags are left out",
+			pt: client.Point{Measurement: "m", Tags: map[string]string{"a": ""},
+				Fields: map[string]interface{}{"v": 1.0}},
+			want: "m v=1\n",
+		},
+		{
+			name: "nothing to write",
+			pt: client.Point{Measurement: "m",
+				Fields: map[string]interface{}{"nan": math.NaN(), "nil": nil}, Time: when},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		appendLine(&buf, tt.pt, tt.precision)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteV2(t *gotest.T) {
+	tests := []struct {
+		status    int
+		ok, retry bool
+	}{
+		{http.StatusNoContent, true, false},
+		{http.StatusOK, true, false},
+		{http.StatusBadRequest, false, false},
+		{http.StatusUnauthorized, false, false},
+		{http.StatusNotFound, false, false},
+		{http.StatusRequestEntityTooLarge, false, false},
+		{http.StatusUnprocessableEntity, false, false},
+		{http.StatusTooManyRequests, false, true},
+		{http.StatusInternalServerError, false, true},
+		{http.StatusServiceUnavailable, false, true},
+	}
+	for _, tt := range tests {
+		var query url.Values
+		var auth string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query, auth = r.URL.Query(), r.Header.Get("Authorization")
+			w.WriteHeader(tt.status)
+			w.Write([]byte("reason"))
+		}))
+		u, _ := url.Parse(ts.URL)
+		port, _ := strconv.Atoi(u.Port())
+		cfg := &InfluxConfig{Host: u.Hostname(), Port: port, Org: "org", Bucket: "bucket",
+			Token: "secret", Precision: "s", http: ts.Client()}
+		bps := &client.BatchPoints{Points: []client.Point{
+			{Measurement: "m", Fields: map[string]interface{}{"v": 1.0}},
+		}}
+		err := cfg.writeV2(bps)
+		ts.Close()
+		if query.Get("bucket") != "bucket" || query.Get("org") != "org" || query.Get("precision") != "s" {
+			t.Errorf("%d: query %v", tt.status, query)
+		}
+		if auth != "Token secret" {
+			t.Errorf("%d: authorization %q", tt.status, auth)
+		}
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%d: %s", tt.status, err)
+			}
+			continue
+		}
+		we, ok := err.(*writeError)
+		if !ok {
+			t.Errorf("%d: got %v, want a writeError", tt.status, err)
+			continue
+		}
+		if we.status != tt.status {
+			t.Errorf("%d: status %d", tt.status, we.status)
+		}
+		if retryable(err) != tt.retry {
+			t.Errorf("%d: retryable %v", tt.status, !tt.retry)
+		}
+	}
+}
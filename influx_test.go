@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	gotest "testing" // as 'testing' is the flag
+
+	"github.com/influxdb/influxdb/client"
+)
+
+func TestDeliver(t *gotest.T) {
+	tests := []struct {
+		status        int
+		delivered     bool
+		sent, dropped int64
+	}{
+		{http.StatusNoContent, true, 1, 0},
+		{http.StatusBadRequest, true, 0, 2},
+		{http.StatusNotFound, true, 0, 2},
+		// retried until the emitter is shut down
+		{http.StatusServiceUnavailable, false, 0, 0},
+	}
+	for _, tt := range tests {
+		var path, db, user string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path, db = r.URL.Path, r.URL.Query().Get("db")
+			user, _, _ = r.BasicAuth()
+			w.WriteHeader(tt.status)
+		}))
+		u, _ := url.Parse(ts.URL)
+		port, _ := strconv.Atoi(u.Port())
+		cfg := &InfluxConfig{Host: u.Hostname(), Port: port, DB: "snmp", User: "me",
+			http: ts.Client(), done: make(chan struct{})}
+		close(cfg.done)
+		bps := cfg.BP()
+		bps.Points = []client.Point{
+			{Measurement: "m", Fields: map[string]interface{}{"v": 1.0}},
+			{Measurement: "m", Fields: map[string]interface{}{"v": 2.0}},
+		}
+		delivered := cfg.deliver(bps)
+		ts.Close()
+		if path != "/write" || db != "snmp" || user != "me" {
+			t.Errorf("%d: wrote to %s db %s as %s", tt.status, path, db, user)
+		}
+		if delivered != tt.delivered || cfg.Sent != tt.sent || cfg.Dropped() != tt.dropped {
+			t.Errorf("%d: delivered %v sent %d dropped %d", tt.status, delivered, cfg.Sent, cfg.Dropped())
+		}
+	}
+}
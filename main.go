@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,47 +16,96 @@ import (
 
 	"github.com/influxdb/influxdb/client"
 	"github.com/kardianos/osext"
-	"github.com/soniah/gosnmp"
 	"gopkg.in/gcfg.v1"
 )
 
 const layout = "2006-01-02 15:04:05"
 
 type SnmpConfig struct {
-	Host      string `gcfg:"host"`
-	Public    string `gcfg:"community"`
-	Port      int    `gcfg:"port"`
-	Retries   int    `gcfg:"retries"`
-	Timeout   int    `gcfg:"timeout"`
-	Repeat    int    `gcfg:"repeat"`
-	Freq      int    `gcfg:"freq"`
-	PortFile  string `gcfg:"portfile"`
-	Config    string `gcfg:"config"`
-	labels    map[string]string
-	asName    map[string]string
-	asOID     map[string]string
-	oids      []string
-	mib       *MibConfig
-	Influx    *InfluxConfig
-	LastError time.Time
-	Requests  int64
-	Gets      int64
-	Errors    int64
-	debugging chan bool
-	enabled   chan chan bool
+	Host        string   `gcfg:"host"`
+	Public      string   `gcfg:"community"`
+	Port        int      `gcfg:"port"`
+	Retries     int      `gcfg:"retries"`
+	Timeout     int      `gcfg:"timeout"`
+	Repeat      int      `gcfg:"repeat"`
+	Freq        int      `gcfg:"freq"`
+	PortFile    string   `gcfg:"portfile"`
+	Config      string   `gcfg:"config"`
+	Output      []string `gcfg:"output"`
+	Version     string   `gcfg:"version"`
+	Username    string   `gcfg:"username"`
+	AuthProto   string   `gcfg:"authproto"`
+	AuthPass    string   `gcfg:"authpass"`
+	PrivProto   string   `gcfg:"privproto"`
+	PrivPass    string   `gcfg:"privpass"`
+	ContextName string   `gcfg:"contextname"`
+	SecLevel    string   `gcfg:"seclevel"`
+	Mib         []string `gcfg:"mib"`     // mib configs to poll
+	Reindex     int      `gcfg:"reindex"` // minutes between looking up the rows again
+	Tag         []string `gcfg:"tag"`     // key:value tags for every point
+	SysTags     bool     `gcfg:"systags"` // tag with sysName and sysLocation
+	ports       map[string]port
+	static      map[string]string // from the config
+	tags        map[string]string // static and sys tags
+	tagLock     sync.RWMutex
+	rules       []portRule
+	groups      []*mibGroup
+	sinks       []Sink
+	targets     []string // names of the influx configs used
+	LastError   time.Time
+	Requests    int64
+	Gets        int64
+	Errors      int64
+	uptime      uint64
+	uptimeAt    time.Time
+	samples     map[string]counterSample
+	indexed     time.Time // when the rows were last looked up
+	stale       bool      // rows looked up have since gone
+	rebooted    bool
+	retry       time.Duration // until looking up the rows again after failing
+	retryAt     time.Time
+	health      sync.Mutex
+	problem     string
+	missing     []string // portfile entries not found on the device
+	name        string
+	debugging   chan bool
+	enabled     chan chan bool
+	stop        chan struct{}
+	done        chan struct{}
 }
 
 type InfluxConfig struct {
-	Host      string `gcfg:"host"`
-	Port      int    `gcfg:"port"`
-	DB        string `gcfg:"db"`
-	User      string `gcfg:"user"`
-	Password  string `gcfg:"password"`
-	Retention string `gcfg:"retention"`
-	iChan     chan *client.BatchPoints
-	conn      *client.Client
-	Sent      int64
-	Errors    int64
+	Host         string `gcfg:"host"`
+	Port         int    `gcfg:"port"`
+	DB           string `gcfg:"db"`
+	User         string `gcfg:"user"`
+	Password     string `gcfg:"password"`
+	Retention    string `gcfg:"retention"`
+	SSL          bool   `gcfg:"ssl"`     // use https
+	Version      string `gcfg:"version"` // 2 or 3 uses the v2 write api
+	Token        string `gcfg:"token"`
+	Org          string `gcfg:"org"`
+	Bucket       string `gcfg:"bucket"`
+	Precision    string `gcfg:"precision"`
+	Spool        bool   `gcfg:"spool"`
+	SpoolMaxSize int    `gcfg:"spoolmaxsize"` // megabytes
+	SpoolMaxAge  int    `gcfg:"spoolmaxage"`  // hours
+	name         string
+	iChan        chan *client.BatchPoints
+	spool        *spool
+	conn         *client.Client
+	http         *http.Client
+	busy         int32 // batch being written
+	done         chan struct{}
+	stopped      chan struct{}
+	closing      sync.Once
+	Sent         int64
+	Errors       int64
+	dropped      int64 // points the server wouldn't accept
+}
+
+type TagsConfig struct {
+	Tag []string `gcfg:"tag"` // key:value tags for every device
 }
 
 type HTTPConfig struct {
@@ -62,46 +113,84 @@ type HTTPConfig struct {
 }
 
 type GeneralConfig struct {
-	LogDir  string `gcfg:"logdir"`
-	OidFile string `gcfg:"oidfile"`
+	LogDir          string   `gcfg:"logdir"`
+	OidFile         string   `gcfg:"oidfile"`
+	SpoolDir        string   `gcfg:"spooldir"`
+	ShutdownTimeout int      `gcfg:"shutdowntimeout"` // seconds
+	MibDir          []string `gcfg:"mibdir"`
 }
 
 type MibConfig struct {
-	Scalers bool     `gcfg:"scalers"`
-	Name    string   `gcfg:"name"`
-	Columns []string `gcfg:"column"`
+	Scalers     bool     `gcfg:"scalers"`
+	Name        string   `gcfg:"name"`
+	Columns     []string `gcfg:"column"`
+	Index       string   `gcfg:"index"`       // column naming the rows, ifName by default
+	Freq        int      `gcfg:"freq"`        // seconds, if not that of the device
+	TagCols     []string `gcfg:"tagcolumn"`   // column[:tag] values to tag rows with
+	Parts       []string `gcfg:"indexpart"`   // name:type, if not known from the MIBs
+	Layout      string   `gcfg:"layout"`      // column (default) or row
+	Measurement string   `gcfg:"measurement"` // templates for naming points
+	Field       string   `gcfg:"field"`
+	TagRename   []string `gcfg:"tagrename"` // old:new
+	TagDrop     []string `gcfg:"tagdrop"`
+	Transform   []string `gcfg:"transform"`   // column:expression
+	Utilisation bool     `gcfg:"utilisation"` // add bps and percent of ifSpeed
+	Speed       []string `gcfg:"speed"`       // column[:scale] for utilisation, in bps
+	Rate        string   `gcfg:"rate"`        // add or only
+	Enum        []string `gcfg:"enum"`        // columns to add labels for
+	Bits        []string `gcfg:"bits"`        // columns to expand into flags
+	Convert     []string `gcfg:"convert"`     // column:type overrides
+	convert     map[string]string
+	tagCols     []tagColumn
+	parts       []indexPart
+	naming      *naming
+	transforms  map[string]expr
+	speeds      []speedColumn
 }
 
 var (
-	quit          = make(chan struct{})
-	verbose       bool
-	startTime     = time.Now()
-	testing       bool
-	snmpNames     bool
-	repeat        = 0
-	freq          = 30
-	httpPort      = 8080
-	oidToName     = make(map[string]string)
-	nameToOid     = make(map[string]string)
-	appdir, _     = osext.ExecutableFolder()
-	logDir        = filepath.Join(appdir, "log")
-	oidFile       = filepath.Join(appdir, "oids.txt")
-	configFile    = filepath.Join(appdir, "config.gcfg")
-	errorLog      *os.File
-	errorDuration = time.Duration(10 * time.Minute)
-	errorPeriod   = errorDuration.String()
-	errorMax      = 100
-	errorName     string
-
-	cfg = struct {
-		Snmp    map[string]*SnmpConfig
-		Mibs    map[string]*MibConfig
-		Influx  map[string]*InfluxConfig
-		HTTP    HTTPConfig
-		General GeneralConfig
-	}{}
+	quit      = make(chan struct{})
+	verbose   bool
+	startTime = time.Now()
+	testing   bool
+	snmpNames bool
+	repeat    = 0
+	freq      = 30
+	httpPort  = 8080
+	oidToName = make(map[string]string)
+	nameToOid = make(map[string]string)
+	appdir, _ = osext.ExecutableFolder()
+	logDir    = filepath.Join(appdir, "log")
+	oidFile   = filepath.Join(appdir, "oids.txt")
+	spoolDir  string
+	mibDirs   []string
+	mibs      *mibTree
+	// how long to wait for influx queues to drain
+	shutdownTimeout = time.Minute
+	configFile      = filepath.Join(appdir, "config.gcfg")
+	errorLog        *os.File
+	errorDuration   = time.Duration(10 * time.Minute)
+	errorPeriod     = errorDuration.String()
+	errorMax        = 100
+	errorName       string
+
+	// cfg is replaced as a whole when reloaded
+	cfg     = &Config{}
+	cfgLock sync.RWMutex
+	oidLock sync.RWMutex
+	traps   *TrapConfig
 )
 
+type Config struct {
+	Snmp    map[string]*SnmpConfig
+	Mibs    map[string]*MibConfig
+	Influx  map[string]*InfluxConfig
+	HTTP    HTTPConfig
+	General GeneralConfig
+	Traps   TrapConfig
+	Tags    TagsConfig
+}
+
 func fatal(v ...interface{}) {
 	log.SetOutput(os.Stderr)
 	log.Fatalln(v...)
@@ -109,21 +198,26 @@ func fatal(v ...interface{}) {
 
 func (c *SnmpConfig) DebugAction() string {
 	debug := make(chan bool)
-	c.enabled <- debug
+	select {
+	case c.enabled <- debug:
+	case <-c.done:
+		return "stopped"
+	}
 	if <-debug {
 		return "disable"
 	}
 	return "enable"
 }
 
-func (c *SnmpConfig) LoadPorts() {
-	c.labels = make(map[string]string)
+func (c *SnmpConfig) LoadPorts() error {
+	c.ports = make(map[string]port)
+	c.rules = nil
 	if len(c.PortFile) == 0 {
-		return
+		return nil
 	}
 	data, err := ioutil.ReadFile(filepath.Join(appdir, c.PortFile))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	for _, line := range strings.Split(string(data), "\n") {
 		// strip comments
@@ -132,11 +226,26 @@ func (c *SnmpConfig) LoadPorts() {
 			line = line[:comment]
 		}
 		f := strings.Fields(line)
-		if len(f) < 2 {
-			continue
+		switch {
+		case len(f) == 0:
+		case isPattern(f[0]):
+			rule, err := parsePortRule(f)
+			if err != nil {
+				return fmt.Errorf("%s: %s", c.PortFile, err)
+			}
+			c.rules = append(c.rules, rule)
+		case len(f) > 1:
+			p, err := parsePort(f[1:])
+			if err != nil {
+				return fmt.Errorf("%s: %s", c.PortFile, err)
+			}
+			if len(p.label) == 0 {
+				return fmt.Errorf("%s: no label for: %s", c.PortFile, f[0])
+			}
+			c.ports[f[0]] = p
 		}
-		c.labels[f[0]] = f[1]
 	}
+	return nil
 }
 
 func (c *SnmpConfig) incRequests() {
@@ -159,40 +268,20 @@ func (c *InfluxConfig) incSent() {
 	atomic.AddInt64(&c.Sent, 1)
 }
 
-// loads [last_octet]name for device
-func (c *SnmpConfig) Translate() {
+// loads [last_octet]name for each table polled
+func (c *SnmpConfig) Translate() error {
 	client, err := snmpClient(c)
 	if err != nil {
-		fatal("Client connect error:", err)
+		return fmt.Errorf("client connect error: %s", err)
 	}
 	defer client.Conn.Close()
 	spew("Looking up column names for:", c.Host)
-	pdus, err := client.BulkWalkAll(nameOid)
-	if err != nil {
-		fatal("SNMP bulkwalk error", err)
-	}
-	c.asName = make(map[string]string)
-	c.asOID = make(map[string]string)
-	for _, pdu := range pdus {
-		switch pdu.Type {
-		case gosnmp.OctetString:
-			i := strings.LastIndex(pdu.Name, ".")
-			suffix := pdu.Name[i+1:]
-			name := string(pdu.Value.([]byte))
-			_, ok := c.labels[name]
-			if len(c.PortFile) == 0 || ok {
-				c.asName[name] = suffix
-				c.asOID[suffix] = name
-			}
+	for _, g := range c.groups {
+		if err := g.translate(client, c); err != nil {
+			return fmt.Errorf("mib config %s: %s", g.name, err)
 		}
 	}
-	// make sure we got everything
-	for k := range c.labels {
-		if _, ok := c.asName[k]; !ok {
-			fatal("No OID found for:", k)
-		}
-	}
-
+	return c.loadSysTags(client)
 }
 
 func spew(x ...interface{}) {
@@ -201,32 +290,231 @@ func spew(x ...interface{}) {
 	}
 }
 
-func (c *SnmpConfig) OIDs() {
-	if c.mib == nil {
-		fatal("NO MIB!")
+func (c *SnmpConfig) OIDs() error {
+	if len(c.groups) == 0 {
+		return fmt.Errorf("no mib for: %s", c.Host)
+	}
+	for _, g := range c.groups {
+		if err := g.setOids(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func oidName(oid string) (string, bool) {
+	oidLock.RLock()
+	name, ok := oidToName[oid]
+	oidLock.RUnlock()
+	return name, ok
+}
+
+func oidFor(name string) (string, bool) {
+	oidLock.RLock()
+	oid, ok := nameToOid[name]
+	oidLock.RUnlock()
+	return oid, ok
+}
+
+// mibObject returns what the MIBs know of the named object
+func mibObject(name string) *mibNode {
+	oidLock.RLock()
+	defer oidLock.RUnlock()
+	return mibs.node(name)
+}
+
+// loadOids reads the oid lookup data and any MIB modules,
+// reporting if it has changed
+func loadOids() (bool, error) {
+	n2o := make(map[string]string)
+	o2n := make(map[string]string)
+	data, err := ioutil.ReadFile(oidFile)
+	switch {
+	case err == nil:
+	case len(mibDirs) > 0 && os.IsNotExist(err):
+		// the MIBs are all that's needed
+	default:
+		return false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		n2o[f[0]] = f[1]
+		o2n[f[1]] = f[0]
 	}
-	c.oids = []string{}
-	for _, col := range c.mib.Columns {
-		base, ok := nameToOid[col]
-		if !ok {
-			fatal("no oid for col:", col)
+	var tree *mibTree
+	if len(mibDirs) > 0 {
+		if tree, err = loadMibs(mibDirs); err != nil {
+			return false, err
 		}
-		// just named columns
-		if len(c.PortFile) > 0 {
-			for k := range c.asOID {
-				c.oids = append(c.oids, base+"."+k)
+		for name, n := range tree.byName {
+			if !strings.Contains(name, "::") {
+				n2o[name] = n.Oid
 			}
-		} else if c.mib.Scalers {
-			// or plain old scaler instances
-			c.oids = append(c.oids, base+".0")
-		} else {
-			c.oids = append(c.oids, base)
+		}
+		for oid, n := range tree.byOid {
+			o2n[oid] = n.Name
+		}
+	}
+	oidLock.Lock()
+	defer oidLock.Unlock()
+	changed := !reflect.DeepEqual(n2o, nameToOid) || !tree.same(mibs)
+	nameToOid, oidToName, mibs = n2o, o2n, tree
+	return changed, nil
+}
+
+// readConfig parses the config file, filling in defaults
+func readConfig() (*Config, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &Config{}
+	if err = gcfg.ReadStringInto(conf, string(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse gcfg data: %s", err)
+	}
+	for name, m := range conf.Mibs {
+		if !validRate(m.Rate) {
+			return nil, fmt.Errorf("invalid rate for mib config %s: %s", name, m.Rate)
+		}
+		if m.convert, err = parseConversions(m.Convert); err != nil {
+			return nil, fmt.Errorf("mib config %s: %s", name, err)
+		}
+		if m.tagCols, err = parseTagColumns(m.TagCols); err != nil {
+			return nil, fmt.Errorf("mib config %s: %s", name, err)
+		}
+		if m.parts, err = parseIndexParts(m.Parts); err != nil {
+			return nil, fmt.Errorf("mib config %s: %s", name, err)
+		}
+		if err = validUtilisation(m); err != nil {
+			return nil, fmt.Errorf("mib config %s: %s", name, err)
+		}
+		if m.transforms, err = parseTransforms(m.Transform, m.Columns); err != nil {
+			return nil, fmt.Errorf("mib config %s: %s", name, err)
+		}
+		if m.naming, err = parseNaming(m); err != nil {
+			return nil, fmt.Errorf("mib config %s: %s", name, err)
+		}
+		switch m.Layout {
+		case "", "column", "row":
+		default:
+			return nil, fmt.Errorf("invalid layout for mib config %s: %s", name, m.Layout)
+		}
+	}
+	for name, c := range conf.Influx {
+		c.name = name
+		if len(c.Retention) == 0 {
+			c.Retention = "default"
+		}
+		if c.v2() && len(c.Precision) == 0 {
+			c.Precision = "ns"
+		}
+	}
+	global, err := parseTags(conf.Tags.Tag)
+	if err != nil {
+		return nil, err
+	}
+	for name, c := range conf.Snmp {
+		c.name = name
+		tags, err := parseTags(c.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("snmp config %s: %s", name, err)
+		}
+		c.static = make(map[string]string)
+		for k, v := range global {
+			c.static[k] = v
+		}
+		for k, v := range tags {
+			c.static[k] = v
+		}
+		c.tags = c.static
+		if c.Freq == 0 {
+			c.Freq = freq
+		}
+		if err := c.LoadPorts(); err != nil {
+			return nil, err
+		}
+		if c.groups, err = conf.groups(c); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// outputs returns the names of the influx configs used by the device
+func (conf *Config) outputs(c *SnmpConfig) ([]string, error) {
+	if len(c.Output) > 0 {
+		// explicitly listed outputs must all exist
+		for _, out := range c.Output {
+			if _, ok := conf.Influx[out]; !ok {
+				return nil, fmt.Errorf("no influx config: %s for snmp device: %s", out, c.name)
+			}
+		}
+		return c.Output, nil
+	}
+	// default is to use name of snmp config, but it can be overridden
+	name := c.name
+	if len(c.Config) > 0 {
+		name = c.Config
+	}
+	if _, ok := conf.Influx[name]; ok {
+		return []string{name}, nil
+	}
+	if _, ok := conf.Influx["*"]; ok {
+		return []string{"*"}, nil
+	}
+	return nil, fmt.Errorf("no influx config for snmp device: %s", name)
+}
+
+// reservedTag rejects the names of the tags every point is given
+func reservedTag(name string) error {
+	if name == "host" || name == "column" {
+		return fmt.Errorf("tag name is reserved: %s", name)
+	}
+	return nil
+}
+
+// parseTags validates 'tag = key:value' entries
+func parseTags(list []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, t := range list {
+		i := strings.Index(t, ":")
+		if i < 1 || i == len(t)-1 {
+			return nil, fmt.Errorf("invalid tag: %s", t)
+		}
+		if err := reservedTag(t[:i]); err != nil {
+			return nil, err
+		}
+		tags[t[:i]] = t[i+1:]
+	}
+	return tags, nil
+}
+
+// prepare looks up what is to be polled on the device
+func (c *SnmpConfig) prepare() error {
+	c.debugging = make(chan bool)
+	c.enabled = make(chan chan bool)
+	for _, g := range c.groups {
+		if err := g.loadEnums(); err != nil {
+			return fmt.Errorf("mib config %s: %s", g.name, err)
 		}
 	}
-	if len(c.mib.Columns) > 0 {
-		spew("COLUMNS", c.mib.Columns)
-		spew(c.oids)
+	// the device being unreachable isn't fatal, it's retried when polling
+	err := c.Translate()
+	switch {
+	case err != nil:
+		errLog("SNMP (%s) translate error: %s\n", c.Host, err)
+		c.backoff(time.Now())
+	case len(c.missingPorts()) > 0:
+		c.indexed = time.Now()
+		c.backoff(c.indexed)
+	default:
+		c.indexed = time.Now()
 	}
+	c.setProblem(err)
+	return c.OIDs()
 }
 
 func flags() *flag.FlagSet {
@@ -253,67 +541,62 @@ func flags() *flag.FlagSet {
 	return &f
 }
 
-func init() {
+// setup reads the flags and config, so that tests can run without them
+func setup() {
 	// parse first time to see if config file is being specified
 	f := flags()
 	f.Parse(os.Args[1:])
 	// now load up config settings
-	if _, err := os.Stat(configFile); err != nil {
+	conf, err := readConfig()
+	if err != nil {
 		log.Fatal(err)
-	} else {
-		data, err := ioutil.ReadFile(configFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		err = gcfg.ReadStringInto(&cfg, string(data))
-		if err != nil {
-			log.Fatalf("Failed to parse gcfg data: %s", err)
-		}
-		httpPort = cfg.HTTP.Port
 	}
+	httpPort = conf.HTTP.Port
 
-	if len(cfg.General.LogDir) > 0 {
-		logDir = cfg.General.LogDir
+	if len(conf.General.LogDir) > 0 {
+		logDir = conf.General.LogDir
 	}
-	if len(cfg.General.OidFile) > 0 {
-		oidFile = cfg.General.OidFile
+	if len(conf.General.OidFile) > 0 {
+		oidFile = conf.General.OidFile
 	}
-	// load oid lookup data
-	data, err := ioutil.ReadFile(oidFile)
-	if err != nil {
-		log.Fatal(err)
+	spoolDir = conf.General.SpoolDir
+	mibDirs = conf.General.MibDir
+	if conf.General.ShutdownTimeout > 0 {
+		shutdownTimeout = time.Duration(conf.General.ShutdownTimeout) * time.Second
 	}
-	for _, line := range strings.Split(string(data), "\n") {
-		f := strings.Fields(line)
-		if len(f) < 2 {
-			continue
-		}
-		nameToOid[f[0]] = f[1]
-		oidToName[f[1]] = f[0]
+
+	// re-read cmd line args to override as indicated
+	f = flags()
+	f.Parse(os.Args[1:])
+	os.Mkdir(logDir, 0755)
+	if len(spoolDir) == 0 {
+		spoolDir = filepath.Join(logDir, "spool")
 	}
 
-	for _, s := range cfg.Snmp {
-		s.LoadPorts()
-		s.debugging = make(chan bool)
-		s.enabled = make(chan chan bool)
+	// open the error log first, so that devices that can't be
+	// prepared (or spools that can't be opened) are logged in it
+	var ferr error
+	errorName = fmt.Sprintf("error.%d.log", conf.HTTP.Port)
+	errorPath := filepath.Join(logDir, errorName)
+	errorLog, ferr = os.OpenFile(errorPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0664)
+	if ferr != nil {
+		log.Fatal("Can't open error log:", ferr)
 	}
-	var ok bool
-	for name, c := range cfg.Snmp {
-		if c.mib, ok = cfg.Mibs[name]; !ok {
-			if c.mib, ok = cfg.Mibs["*"]; !ok {
-				fatal("No mib data found for config:", name)
-			}
-		}
-		c.Translate()
-		c.OIDs()
-		if c.Freq == 0 {
-			c.Freq = freq
+
+	// load oid lookup data
+	if _, err := loadOids(); err != nil {
+		log.Fatal(err)
+	}
+
+	for name, c := range conf.Snmp {
+		if err := c.prepare(); err != nil {
+			fatal("snmp config:", name, "error:", err)
 		}
 	}
 
 	// only run when one needs to see the interface names of the device
 	if snmpNames {
-		for _, c := range cfg.Snmp {
+		for _, c := range conf.Snmp {
 			fmt.Println("\nSNMP host:", c.Host)
 			fmt.Println("=========================================")
 			printSnmpNames(c)
@@ -321,32 +604,23 @@ func init() {
 		os.Exit(0)
 	}
 
-	// re-read cmd line args to override as indicated
-	f = flags()
-	f.Parse(os.Args[1:])
-	os.Mkdir(logDir, 0755)
-
 	// now make sure each snmp device has a db
-	for name, c := range cfg.Snmp {
-		// default is to use name of snmp config, but it can be overridden
-		if len(c.Config) > 0 {
-			name = c.Config
-		}
-		if c.Influx, ok = cfg.Influx[name]; !ok {
-			if c.Influx, ok = cfg.Influx["*"]; !ok {
-				fatal("No influx config for snmp device:", name)
+	for _, c := range conf.Snmp {
+		outputs, err := conf.outputs(c)
+		if err != nil {
+			fatal(err)
+		}
+		for _, name := range outputs {
+			i := conf.Influx[name]
+			if err := i.Init(); err != nil {
+				fatal("influx config:", name, "error:", err)
 			}
+			c.sinks = append(c.sinks, i)
 		}
-		c.Influx.Init()
-	}
-
-	var ferr error
-	errorName = fmt.Sprintf("error.%d.log", cfg.HTTP.Port)
-	errorPath := filepath.Join(logDir, errorName)
-	errorLog, ferr = os.OpenFile(errorPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0664)
-	if ferr != nil {
-		log.Fatal("Can't open error log:", ferr)
+		c.targets = outputs
 	}
+	cfg = conf
+	traps = &cfg.Traps
 }
 
 func errLog(msg string, args ...interface{}) {
@@ -360,16 +634,19 @@ func errMsg(msg string, err error) {
 }
 
 func main() {
-	var wg sync.WaitGroup
-	defer func() {
-		errorLog.Close()
-	}()
+	setup()
 	for _, c := range cfg.Snmp {
-		wg.Add(1)
-		go c.Gather(repeat, &wg)
+		c.start(repeat)
+	}
+	if traps.Port > 0 {
+		traps.Init(cfg.Snmp)
+		go trapListener(traps)
 	}
+	go reloader()
+	go shutdowner()
 	if repeat > 0 {
-		wg.Wait()
+		gatherers.Wait()
+		os.Exit(shutdown())
 	} else {
 		if httpPort > 0 {
 			webServer(httpPort)
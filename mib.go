@@ -0,0 +1,957 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A native SMIv1/SMIv2 MIB module parser, so that any vendor MIB can be
+// used without running snmptranslate. It only understands enough of
+// ASN.1 to build the OID tree along with the type information
+// (enums, bits, display hints, table indexes) needed by the collector.
+
+// mibNode is a named object in the MIB tree
+type mibNode struct {
+	Name     string
+	Module   string
+	Oid      string
+	Kind     string // OBJECT-TYPE, OBJECT IDENTIFIER, NOTIFICATION-TYPE, etc.
+	Syntax   string // base type, e.g., INTEGER, OCTET STRING, Counter64
+	TC       string // textual convention, if any
+	Hint     string // DISPLAY-HINT
+	Units    string
+	Access   string
+	Enums    map[int]string // INTEGER enumerations
+	Bits     map[int]string // BITS labels
+	Index    []string       // INDEX of a table row
+	Implied  bool           // last index is IMPLIED
+	Augments string
+	parent   *mibNode
+	children []*mibNode
+}
+
+// IsRow reports if the node is a conceptual table row
+func (n *mibNode) IsRow() bool {
+	return len(n.Index) > 0 || len(n.Augments) > 0
+}
+
+// IsColumn reports if the node is a column of a table
+func (n *mibNode) IsColumn() bool {
+	return n.parent != nil && n.parent.IsRow()
+}
+
+// Columns returns the columns of a table row, in OID order
+func (n *mibNode) Columns() []*mibNode {
+	var cols []*mibNode
+	for _, c := range n.children {
+		if c.Kind == "OBJECT-TYPE" {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// mibTree holds all the objects from the loaded MIB modules
+type mibTree struct {
+	byName map[string]*mibNode // name and MODULE::name
+	byOid  map[string]*mibNode
+}
+
+// node returns the named object, the name can be qualified by its module
+func (t *mibTree) node(name string) *mibNode {
+	if t == nil {
+		return nil
+	}
+	return t.byName[name]
+}
+
+// parent returns the closest object at or above the oid
+func (t *mibTree) parent(oid string) *mibNode {
+	if t == nil {
+		return nil
+	}
+	oid = strings.TrimPrefix(oid, ".")
+	for len(oid) > 0 {
+		if n, ok := t.byOid[oid]; ok {
+			return n
+		}
+		i := strings.LastIndex(oid, ".")
+		if i < 0 {
+			break
+		}
+		oid = oid[:i]
+	}
+	return nil
+}
+
+// row returns the table row that contains the column (resolving AUGMENTS)
+func (t *mibTree) row(col *mibNode) *mibNode {
+	if col == nil || !col.IsColumn() {
+		return nil
+	}
+	row := col.parent
+	for i := 0; i < 4 && len(row.Augments) > 0 && len(row.Index) == 0; i++ {
+		base := t.node(row.Augments)
+		if base == nil {
+			break
+		}
+		row = base
+	}
+	return row
+}
+
+// same reports if the trees define the same objects, with the same
+// types, enumerations and indexes
+func (t *mibTree) same(o *mibTree) bool {
+	if t == nil || o == nil {
+		return t == o
+	}
+	if len(t.byName) != len(o.byName) || len(t.byOid) != len(o.byOid) {
+		return false
+	}
+	for name, n := range t.byName {
+		if m, ok := o.byName[name]; !ok || m.Oid != n.Oid {
+			return false
+		}
+	}
+	for oid, n := range t.byOid {
+		m, ok := o.byOid[oid]
+		if !ok {
+			return false
+		}
+		a, b := *n, *m
+		a.parent, a.children, b.parent, b.children = nil, nil, nil, nil
+		if !reflect.DeepEqual(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// syntaxes that aren't defined by any module
+var mibBaseTypes = map[string]bool{
+	"INTEGER":           true,
+	"OCTET STRING":      true,
+	"OBJECT IDENTIFIER": true,
+	"BITS":              true,
+	"Integer32":         true,
+	"Unsigned32":        true,
+	"Counter32":         true,
+	"Counter64":         true,
+	"Gauge32":           true,
+	"TimeTicks":         true,
+	"IpAddress":         true,
+	"Opaque":            true,
+	"Counter":           true, // SMIv1
+	"Gauge":             true, // SMIv1
+	"NetworkAddress":    true, // SMIv1
+}
+
+// textual conventions used often enough to be worth knowing
+// even when SNMPv2-TC isn't loaded
+var mibBuiltinTCs = map[string]*mibType{
+	"DisplayString":       {base: "OCTET STRING", hint: "255a"},
+	"SnmpAdminString":     {base: "OCTET STRING", hint: "255t"},
+	"PhysAddress":         {base: "OCTET STRING", hint: "1x:"},
+	"MacAddress":          {base: "OCTET STRING", hint: "1x:"},
+	"DateAndTime":         {base: "OCTET STRING", hint: "2d-1d-1d,1d:1d:1d.1d,1a1d:1d"},
+	"TimeStamp":           {base: "TimeTicks"},
+	"TimeInterval":        {base: "INTEGER"},
+	"TruthValue":          {base: "INTEGER", enums: map[int]string{1: "true", 2: "false"}},
+	"InterfaceIndex":      {base: "Integer32", hint: "d"},
+	"AutonomousType":      {base: "OBJECT IDENTIFIER"},
+	"CounterBasedGauge64": {base: "Counter64"},
+}
+
+// roots of the oid tree, and the SMI definitions most modules import
+var mibBuiltinOids = map[string]string{
+	"ccitt":           "0",
+	"iso":             "1",
+	"joint-iso-ccitt": "2",
+	"org":             "1.3",
+	"dod":             "1.3.6",
+	"internet":        "1.3.6.1",
+	"directory":       "1.3.6.1.1",
+	"mgmt":            "1.3.6.1.2",
+	"mib-2":           "1.3.6.1.2.1",
+	"transmission":    "1.3.6.1.2.1.10",
+	"experimental":    "1.3.6.1.3",
+	"private":         "1.3.6.1.4",
+	"enterprises":     "1.3.6.1.4.1",
+	"security":        "1.3.6.1.5",
+	"snmpV2":          "1.3.6.1.6",
+	"snmpDomains":     "1.3.6.1.6.1",
+	"snmpProxys":      "1.3.6.1.6.2",
+	"snmpModules":     "1.3.6.1.6.3",
+	"zeroDotZero":     "0.0",
+}
+
+// mibType is a parsed SYNTAX or textual convention
+type mibType struct {
+	base  string
+	tc    string // named type this refines
+	hint  string
+	enums map[int]string
+	bits  map[int]string
+}
+
+// oidComp is one component of an OID value, e.g., 'mib-2', '2' or 'org(3)'
+type oidComp struct {
+	name   string
+	num    int
+	hasNum bool
+}
+
+type mibDef struct {
+	node  *mibNode
+	comps []oidComp
+	typ   *mibType
+}
+
+type mibModule struct {
+	name    string
+	imports map[string]string // symbol -> module
+	defs    map[string]*mibDef
+	types   map[string]*mibType
+}
+
+//
+// lexer
+//
+
+type mibLexer struct {
+	src  []byte
+	pos  int
+	line int
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokSymbol
+)
+
+type mibToken struct {
+	kind int
+	text string
+	line int
+}
+
+func isIdentChar(c byte) bool {
+	return c == '-' || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (l *mibLexer) next() mibToken {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			l.pos++
+		case c == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '-':
+			// comments run to the end of the line or the next '--'
+			l.pos += 2
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				if l.src[l.pos] == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '-' {
+					l.pos += 2
+					break
+				}
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return mibToken{kind: tokEOF, line: l.line}
+}
+
+func (l *mibLexer) token() mibToken {
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case c == '"':
+		var b []byte
+		for l.pos++; l.pos < len(l.src); l.pos++ {
+			c := l.src[l.pos]
+			if c == '"' {
+				if l.pos+1 < len(l.src) && l.src[l.pos+1] == '"' {
+					b = append(b, c)
+					l.pos++
+					continue
+				}
+				l.pos++
+				break
+			}
+			if c == '\n' {
+				l.line++
+			}
+			b = append(b, c)
+		}
+		return mibToken{tokString, string(b), l.line}
+	case c == '\'':
+		// hex or binary string, e.g., '0a'H
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] != '\''; l.pos++ {
+		}
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == 'H' || l.src[l.pos] == 'h' || l.src[l.pos] == 'B' || l.src[l.pos] == 'b') {
+			l.pos++
+		}
+		return mibToken{tokString, string(l.src[start:l.pos]), l.line}
+	case c == ':' && l.pos+2 < len(l.src) && l.src[l.pos+1] == ':' && l.src[l.pos+2] == '=':
+		l.pos += 3
+		return mibToken{tokSymbol, "::=", l.line}
+	case c == '.' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '.':
+		l.pos += 2
+		return mibToken{tokSymbol, "..", l.line}
+	case (c >= '0' && c <= '9') || (c == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9'):
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9'; l.pos++ {
+		}
+		return mibToken{tokNumber, string(l.src[start:l.pos]), l.line}
+	case isIdentChar(c):
+		for l.pos++; l.pos < len(l.src) && isIdentChar(l.src[l.pos]); l.pos++ {
+			// a comment can follow an identifier without a space
+			if l.src[l.pos] == '-' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '-' {
+				break
+			}
+		}
+		return mibToken{tokIdent, string(l.src[start:l.pos]), l.line}
+	}
+	l.pos++
+	return mibToken{tokSymbol, string(c), l.line}
+}
+
+//
+// parser
+//
+
+type mibParser struct {
+	toks []mibToken
+	pos  int
+	file string
+}
+
+func newMibParser(file string, src []byte) *mibParser {
+	l := &mibLexer{src: src, line: 1}
+	p := &mibParser{file: file}
+	for {
+		t := l.next()
+		p.toks = append(p.toks, t)
+		if t.kind == tokEOF {
+			return p
+		}
+	}
+}
+
+func (p *mibParser) peek() mibToken {
+	return p.toks[p.pos]
+}
+
+func (p *mibParser) next() mibToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *mibParser) is(text string) bool {
+	t := p.peek()
+	return t.kind != tokString && t.text == text
+}
+
+func (p *mibParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.file, p.peek().line, fmt.Sprintf(format, args...))
+}
+
+func (p *mibParser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q, found %q", text, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+// skipBalanced skips a bracketed group, e.g., { ... } or ( ... )
+func (p *mibParser) skipBalanced() {
+	open := p.next().text
+	close := map[string]string{"{": "}", "(": ")", "[": "]"}[open]
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return
+		case t.kind == tokSymbol && t.text == open:
+			depth++
+		case t.kind == tokSymbol && t.text == close:
+			depth--
+		}
+	}
+}
+
+// skipTo skips up to and including the token
+func (p *mibParser) skipTo(text string) {
+	for t := p.next(); t.kind != tokEOF; t = p.next() {
+		if t.kind != tokString && t.text == text {
+			return
+		}
+	}
+}
+
+func (p *mibParser) modules() ([]*mibModule, error) {
+	var mods []*mibModule
+	for p.peek().kind != tokEOF {
+		m, err := p.module()
+		if err != nil {
+			return mods, err
+		}
+		mods = append(mods, m)
+	}
+	return mods, nil
+}
+
+func (p *mibParser) module() (*mibModule, error) {
+	name := p.next()
+	if name.kind != tokIdent {
+		return nil, p.errorf("expected module name, found %q", name.text)
+	}
+	m := &mibModule{
+		name:    name.text,
+		imports: make(map[string]string),
+		defs:    make(map[string]*mibDef),
+		types:   make(map[string]*mibType),
+	}
+	if p.is("{") {
+		p.skipBalanced()
+	}
+	if err := p.expect("DEFINITIONS"); err != nil {
+		return nil, err
+	}
+	p.skipTo("::=")
+	if err := p.expect("BEGIN"); err != nil {
+		return nil, err
+	}
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return nil, p.errorf("unexpected end of module %s", m.name)
+		case t.text == "END":
+			return m, nil
+		case t.text == "IMPORTS":
+			p.imports(m)
+		case t.text == "EXPORTS":
+			p.skipTo(";")
+		case t.kind == tokIdent:
+			if err := p.assignment(m, t.text); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (p *mibParser) imports(m *mibModule) {
+	var names []string
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF || t.text == ";":
+			return
+		case t.text == "FROM":
+			from := p.next().text
+			for _, name := range names {
+				m.imports[name] = from
+			}
+			names = names[:0]
+		case t.kind == tokIdent:
+			names = append(names, t.text)
+		}
+	}
+}
+
+func (p *mibParser) assignment(m *mibModule, name string) error {
+	switch {
+	case p.is("MACRO"):
+		p.skipTo("END")
+		return nil
+	case p.is("::="):
+		p.next()
+		m.types[name] = p.typeAssignment()
+		return nil
+	case p.is("OBJECT"):
+		p.next()
+		if err := p.expect("IDENTIFIER"); err != nil {
+			return err
+		}
+		if err := p.expect("::="); err != nil {
+			return err
+		}
+		comps, err := p.oidValue()
+		if err != nil {
+			return err
+		}
+		m.defs[name] = &mibDef{node: &mibNode{Name: name, Module: m.name, Kind: "OBJECT IDENTIFIER"}, comps: comps}
+		return nil
+	}
+	kind := p.next()
+	if kind.kind != tokIdent {
+		// something that isn't of interest, e.g., a value assignment
+		p.skipTo("::=")
+		p.next()
+		return nil
+	}
+	node := &mibNode{Name: name, Module: m.name, Kind: kind.text}
+	def := &mibDef{node: node}
+	var enterprise string
+	for !p.is("::=") {
+		t := p.next()
+		if t.kind == tokEOF {
+			return p.errorf("unexpected end of %s", name)
+		}
+		switch t.text {
+		case "SYNTAX":
+			def.typ = p.syntax()
+		case "UNITS":
+			node.Units = p.next().text
+		case "DISPLAY-HINT":
+			node.Hint = p.next().text
+		case "ACCESS", "MAX-ACCESS":
+			node.Access = p.next().text
+		case "INDEX":
+			node.Index, node.Implied = p.index()
+		case "AUGMENTS":
+			if aug, _ := p.index(); len(aug) > 0 {
+				node.Augments = aug[0]
+			}
+		case "ENTERPRISE":
+			if p.is("{") {
+				list, _ := p.index()
+				if len(list) > 0 {
+					enterprise = list[0]
+				}
+			} else {
+				enterprise = p.next().text
+			}
+		default:
+			if p.is("{") || p.is("(") {
+				p.skipBalanced()
+			}
+		}
+	}
+	p.next()
+	if kind.text == "TRAP-TYPE" {
+		// SMIv1 traps are numbered under their enterprise
+		n, err := strconv.Atoi(p.next().text)
+		if err != nil || len(enterprise) == 0 {
+			return nil
+		}
+		def.comps = []oidComp{{name: enterprise}, {num: 0, hasNum: true}, {num: n, hasNum: true}}
+		m.defs[name] = def
+		return nil
+	}
+	if !p.is("{") {
+		p.next()
+		return nil
+	}
+	comps, err := p.oidValue()
+	if err != nil {
+		return err
+	}
+	def.comps = comps
+	m.defs[name] = def
+	return nil
+}
+
+// typeAssignment is either a TEXTUAL-CONVENTION or a plain type
+func (p *mibParser) typeAssignment() *mibType {
+	if !p.is("TEXTUAL-CONVENTION") {
+		return p.syntax()
+	}
+	p.next()
+	var hint string
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return &mibType{}
+		case t.text == "DISPLAY-HINT":
+			hint = p.next().text
+		case t.text == "SYNTAX":
+			typ := p.syntax()
+			typ.hint = hint
+			return typ
+		}
+	}
+}
+
+// syntax parses a type, noting the base type, enums and bits
+func (p *mibParser) syntax() *mibType {
+	typ := &mibType{}
+	t := p.next()
+	switch t.text {
+	case "[":
+		// tagged types from the SMI, e.g., [APPLICATION 1] IMPLICIT INTEGER
+		p.skipTo("]")
+		if p.is("IMPLICIT") || p.is("EXPLICIT") {
+			p.next()
+		}
+		return p.syntax()
+	case "OCTET":
+		p.expect("STRING")
+		typ.base = "OCTET STRING"
+	case "OBJECT":
+		p.expect("IDENTIFIER")
+		typ.base = "OBJECT IDENTIFIER"
+	case "SEQUENCE":
+		if p.is("OF") {
+			p.next()
+			p.next()
+			typ.base = "SEQUENCE OF"
+		} else {
+			if p.is("{") {
+				p.skipBalanced()
+			}
+			typ.base = "SEQUENCE"
+		}
+	case "CHOICE":
+		if p.is("{") {
+			p.skipBalanced()
+		}
+		typ.base = "CHOICE"
+	case "INTEGER":
+		typ.base = "INTEGER"
+		if p.is("{") {
+			typ.enums = p.namedNumbers()
+		}
+	case "BITS":
+		typ.base = "BITS"
+		if p.is("{") {
+			typ.bits = p.namedNumbers()
+		}
+	default:
+		if mibBaseTypes[t.text] {
+			typ.base = t.text
+		} else {
+			typ.tc = t.text
+		}
+		if p.is("{") {
+			typ.enums = p.namedNumbers()
+		}
+	}
+	// size and range constraints are of no interest
+	for p.is("(") {
+		p.skipBalanced()
+	}
+	return typ
+}
+
+// namedNumbers parses { label(n), ... }
+func (p *mibParser) namedNumbers() map[int]string {
+	nums := make(map[int]string)
+	p.next()
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF || t.text == "}":
+			return nums
+		case t.kind == tokIdent && p.is("("):
+			p.next()
+			if n, err := strconv.Atoi(p.next().text); err == nil {
+				nums[n] = t.text
+			}
+			p.skipTo(")")
+		}
+	}
+}
+
+// index parses { [IMPLIED] name, ... }
+func (p *mibParser) index() ([]string, bool) {
+	var names []string
+	implied := false
+	if !p.is("{") {
+		return nil, false
+	}
+	p.next()
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF || t.text == "}":
+			return names, implied
+		case t.text == "IMPLIED":
+			implied = true
+		case t.kind == tokIdent:
+			names = append(names, t.text)
+		}
+	}
+}
+
+// oidValue parses { parent 1 }, { iso org(3) 6 } and the like
+func (p *mibParser) oidValue() ([]oidComp, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var comps []oidComp
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return nil, p.errorf("unterminated oid value")
+		case t.text == "}":
+			return comps, nil
+		case t.kind == tokNumber:
+			n, _ := strconv.Atoi(t.text)
+			comps = append(comps, oidComp{num: n, hasNum: true})
+		case t.kind == tokIdent:
+			c := oidComp{name: t.text}
+			if p.is("(") {
+				p.next()
+				if n, err := strconv.Atoi(p.next().text); err == nil {
+					c.num, c.hasNum = n, true
+				}
+				p.skipTo(")")
+			}
+			comps = append(comps, c)
+		}
+	}
+}
+
+//
+// resolution of names across modules
+//
+
+type mibLoader struct {
+	modules map[string]*mibModule
+	byName  map[string][]*mibModule // modules defining a symbol
+	names   []string                // of the modules, sorted
+}
+
+// lookup finds what a name refers to from within a module, trying
+// the module itself, where it was imported from and then any module
+func (l *mibLoader) lookup(m *mibModule, name string) *mibDef {
+	if d, ok := m.defs[name]; ok {
+		return d
+	}
+	if from, ok := m.imports[name]; ok {
+		if mod, ok := l.modules[from]; ok {
+			if d, ok := mod.defs[name]; ok {
+				return d
+			}
+		}
+	}
+	for _, mod := range l.byName[name] {
+		return mod.defs[name]
+	}
+	return nil
+}
+
+func (l *mibLoader) lookupType(m *mibModule, name string) *mibType {
+	if t, ok := m.types[name]; ok {
+		return t
+	}
+	if from, ok := m.imports[name]; ok {
+		if mod, ok := l.modules[from]; ok {
+			if t, ok := mod.types[name]; ok {
+				return t
+			}
+		}
+	}
+	for _, from := range l.names {
+		if t, ok := l.modules[from].types[name]; ok {
+			return t
+		}
+	}
+	return mibBuiltinTCs[name]
+}
+
+// resolveOid returns the oid of a definition, or false if its parent is unknown (yet)
+func (l *mibLoader) resolveOid(m *mibModule, def *mibDef) bool {
+	if len(def.node.Oid) > 0 {
+		return true
+	}
+	if len(def.comps) == 0 {
+		return false
+	}
+	var parts []string
+	first := def.comps[0]
+	switch {
+	case first.hasNum:
+		parts = append(parts, strconv.Itoa(first.num))
+	default:
+		if d := l.lookup(m, first.name); d != nil && d != def {
+			if len(d.node.Oid) == 0 {
+				return false
+			}
+			parts = append(parts, d.node.Oid)
+		} else if oid, ok := mibBuiltinOids[first.name]; ok {
+			parts = append(parts, oid)
+		} else {
+			return false
+		}
+	}
+	for _, c := range def.comps[1:] {
+		if !c.hasNum {
+			return false
+		}
+		parts = append(parts, strconv.Itoa(c.num))
+	}
+	def.node.Oid = strings.Join(parts, ".")
+	return true
+}
+
+// resolveType flattens a chain of textual conventions into the node
+func (l *mibLoader) resolveType(m *mibModule, n *mibNode, typ *mibType) {
+	if typ == nil {
+		return
+	}
+	n.Enums, n.Bits = typ.enums, typ.bits
+	if len(typ.tc) > 0 {
+		n.TC = typ.tc
+	}
+	for i := 0; i < 8 && len(typ.tc) > 0 && len(typ.base) == 0; i++ {
+		next := l.lookupType(m, typ.tc)
+		if next == nil {
+			break
+		}
+		if len(n.Hint) == 0 {
+			n.Hint = next.hint
+		}
+		if n.Enums == nil {
+			n.Enums = next.enums
+		}
+		if n.Bits == nil {
+			n.Bits = next.bits
+		}
+		typ = next
+	}
+	n.Syntax = typ.base
+	if len(n.Syntax) == 0 {
+		n.Syntax = typ.tc
+	}
+}
+
+// loadMibs parses every MIB module found in the directories
+func loadMibs(dirs []string) (*mibTree, error) {
+	l := &mibLoader{
+		modules: make(map[string]*mibModule),
+		byName:  make(map[string][]*mibModule),
+	}
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			switch strings.ToLower(filepath.Ext(fi.Name())) {
+			case "", ".mib", ".txt", ".my", ".smi":
+			default:
+				continue
+			}
+			name := filepath.Join(dir, fi.Name())
+			src, err := ioutil.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			mods, err := newMibParser(name, src).modules()
+			if err != nil {
+				// a broken vendor MIB shouldn't stop everything else loading
+				log.Println("mib parse error:", err)
+			}
+			for _, m := range mods {
+				l.modules[m.name] = m
+			}
+		}
+	}
+	// modules are processed in name order so results are repeatable
+	var names []string
+	for name := range l.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := l.modules[name]
+		for sym := range m.defs {
+			l.byName[sym] = append(l.byName[sym], m)
+		}
+	}
+	l.names = names
+
+	// keep going until nothing more can be resolved
+	for progress := true; progress; {
+		progress = false
+		for _, name := range names {
+			m := l.modules[name]
+			for _, def := range m.defs {
+				if len(def.node.Oid) == 0 && l.resolveOid(m, def) {
+					progress = true
+				}
+			}
+		}
+	}
+
+	tree := &mibTree{
+		byName: make(map[string]*mibNode),
+		byOid:  make(map[string]*mibNode),
+	}
+	unresolved := 0
+	for _, name := range names {
+		m := l.modules[name]
+		for sym, def := range m.defs {
+			n := def.node
+			if len(n.Oid) == 0 {
+				unresolved++
+				spew("unresolved mib object:", m.name+"::"+sym)
+				continue
+			}
+			l.resolveType(m, n, def.typ)
+			tree.byName[m.name+"::"+sym] = n
+			if _, ok := tree.byName[sym]; !ok {
+				tree.byName[sym] = n
+			}
+			if _, ok := tree.byOid[n.Oid]; !ok {
+				tree.byOid[n.Oid] = n
+			}
+		}
+	}
+	for _, n := range tree.byOid {
+		if i := strings.LastIndex(n.Oid, "."); i > 0 {
+			if p, ok := tree.byOid[n.Oid[:i]]; ok {
+				n.parent = p
+				p.children = append(p.children, n)
+			}
+		}
+	}
+	for _, n := range tree.byOid {
+		sort.Slice(n.children, func(i, j int) bool {
+			return oidLess(n.children[i].Oid, n.children[j].Oid)
+		})
+	}
+	if unresolved > 0 {
+		log.Printf("loaded %d mib modules, %d objects could not be resolved\n", len(l.modules), unresolved)
+	}
+	return tree, nil
+}
+
+// oidLess compares oids numerically
+func oidLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
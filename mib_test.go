@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	gotest "testing" // as 'testing' is the flag
+)
+
+var testMibs = map[string]string{
+	"TEST-TC-MIB.mib": `
+TEST-TC-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    enterprises FROM RFC1155-SMI
+    TEXTUAL-CONVENTION FROM SNMPv2-TC;
+
+testRoot OBJECT IDENTIFIER ::= { enterprises 99999 }
+
+TestStatus ::= TEXTUAL-CONVENTION
+    STATUS      current
+    DESCRIPTION "up or down"
+    SYNTAX      INTEGER { up(1), down(2) }
+
+END
+`,
+	"TEST-MIB.mib": `
+TEST-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    OBJECT-TYPE, Integer32, Counter64 FROM SNMPv2-SMI
+    DisplayString FROM SNMPv2-TC
+    testRoot, TestStatus FROM TEST-TC-MIB;
+
+testTable OBJECT-TYPE
+    SYNTAX      SEQUENCE OF TestEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "a table"
+    ::= { testRoot 1 }
+
+testEntry OBJECT-TYPE
+    SYNTAX      TestEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "a row"
+    INDEX       { testIndex, IMPLIED testName }
+    ::= { testTable 1 }
+
+TestEntry ::= SEQUENCE {
+    testIndex   Integer32,
+    testName    DisplayString,
+    testStatus  TestStatus,
+    testFlags   BITS,
+    testOctets  Counter64
+}
+
+testIndex OBJECT-TYPE
+    SYNTAX      Integer32 (1..65535)
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "index"
+    ::= { testEntry 1 }
+
+testName OBJECT-TYPE
+    SYNTAX      DisplayString (SIZE (1..32))
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "name"
+    ::= { testEntry 2 }
+
+testStatus OBJECT-TYPE
+    SYNTAX      TestStatus
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "status"
+    ::= { testEntry 3 }
+
+testFlags OBJECT-TYPE
+    SYNTAX      BITS { red(0), green(1), blue(2) }
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "flags"
+    ::= { testEntry 4 }
+
+testOctets OBJECT-TYPE
+    SYNTAX      Counter64
+    UNITS       "octets"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "octets"
+    ::= { testEntry 5 }
+
+END
+`,
+}
+
+func TestLoadMibs(t *gotest.T) {
+	dir, err := ioutil.TempDir("", "mibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range testMibs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := loadMibs([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		oid     string
+		syntax  string
+		tc      string
+		units   string
+		enums   map[int]string
+		bits    map[int]string
+		index   []string
+		implied bool
+	}{
+		{name: "testRoot", oid: "1.3.6.1.4.1.99999"},
+		{name: "TEST-TC-MIB::testRoot", oid: "1.3.6.1.4.1.99999"},
+		{name: "testEntry", oid: "1.3.6.1.4.1.99999.1.1",
+			index: []string{"testIndex", "testName"}, implied: true},
+		{name: "testIndex", oid: "1.3.6.1.4.1.99999.1.1.1", syntax: "Integer32"},
+		{name: "testName", oid: "1.3.6.1.4.1.99999.1.1.2",
+			syntax: "OCTET STRING", tc: "DisplayString"},
+		{name: "testStatus", oid: "1.3.6.1.4.1.99999.1.1.3",
+			syntax: "INTEGER", tc: "TestStatus", enums: map[int]string{1: "up", 2: "down"}},
+		{name: "testFlags", oid: "1.3.6.1.4.1.99999.1.1.4",
+			syntax: "BITS", bits: map[int]string{0: "red", 1: "green", 2: "blue"}},
+		{name: "TEST-MIB::testOctets", oid: "1.3.6.1.4.1.99999.1.1.5",
+			syntax: "Counter64", units: "octets"},
+	}
+	for _, tt := range tests {
+		n := tree.node(tt.name)
+		if n == nil {
+			t.Errorf("%s: not found", tt.name)
+			continue
+		}
+		if n.Oid != tt.oid {
+			t.Errorf("%s: oid %s, want %s", tt.name, n.Oid, tt.oid)
+		}
+		if len(tt.syntax) > 0 && n.Syntax != tt.syntax {
+			t.Errorf("%s: syntax %q, want %q", tt.name, n.Syntax, tt.syntax)
+		}
+		if len(tt.tc) > 0 && n.TC != tt.tc {
+			t.Errorf("%s: tc %q, want %q", tt.name, n.TC, tt.tc)
+		}
+		if n.Units != tt.units {
+			t.Errorf("%s: units %q, want %q", tt.name, n.Units, tt.units)
+		}
+		if tt.enums != nil && !reflect.DeepEqual(n.Enums, tt.enums) {
+			t.Errorf("%s: enums %v, want %v", tt.name, n.Enums, tt.enums)
+		}
+		if tt.bits != nil && !reflect.DeepEqual(n.Bits, tt.bits) {
+			t.Errorf("%s: bits %v, want %v", tt.name, n.Bits, tt.bits)
+		}
+		if !reflect.DeepEqual(n.Index, tt.index) || n.Implied != tt.implied {
+			t.Errorf("%s: index %v implied %v, want %v %v", tt.name, n.Index, n.Implied, tt.index, tt.implied)
+		}
+	}
+	if row := tree.row(tree.node("testOctets")); row == nil || row.Name != "testEntry" {
+		t.Errorf("row of testOctets: %v", row)
+	}
+}
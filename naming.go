@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// functions available to the naming templates
+var nameFuncs = template.FuncMap{
+	"snake": snake,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"trimPrefix": func(prefix, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"trimSuffix": func(suffix, s string) string {
+		return strings.TrimSuffix(s, suffix)
+	},
+}
+
+// nameData is what the naming templates can refer to
+type nameData struct {
+	Mib    string // name of the mib config, or the group
+	Group  string // name of the [mibs] section
+	Column string // MIB column, empty for the measurement of a row
+	Row    string // the 'column' tag, i.e., the port label
+	Host   string
+}
+
+// naming is how a group's points are named, if not as they come
+type naming struct {
+	measurement *template.Template
+	field       *template.Template
+	rename      map[string]string
+	drop        map[string]bool
+}
+
+// parseNaming validates the naming templates and tag rules of the mib config
+func parseNaming(m *MibConfig) (*naming, error) {
+	n := &naming{rename: make(map[string]string), drop: make(map[string]bool)}
+	var err error
+	if len(m.Measurement) > 0 {
+		if n.measurement, err = template.New("measurement").Funcs(nameFuncs).Parse(m.Measurement); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Field) > 0 {
+		if n.field, err = template.New("field").Funcs(nameFuncs).Parse(m.Field); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range m.TagRename {
+		i := strings.Index(r, ":")
+		if i < 1 || i == len(r)-1 {
+			return nil, fmt.Errorf("invalid tag rename: %s", r)
+		}
+		n.rename[r[:i]] = r[i+1:]
+	}
+	for _, d := range m.TagDrop {
+		n.drop[d] = true
+	}
+	return n, nil
+}
+
+// name executes the template, falling back to the default name
+// should it fail or come out empty
+func (n *naming) name(t *template.Template, data *nameData, def string) string {
+	if t == nil {
+		return def
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		log.Println("naming template error:", err)
+		return def
+	}
+	if b.Len() == 0 {
+		return def
+	}
+	return b.String()
+}
+
+// fields renames the raw value to the templated field name,
+// and anything computed from it as that name plus a suffix
+func (n *naming) fields(fields map[string]interface{}, base string) map[string]interface{} {
+	named := make(map[string]interface{}, len(fields))
+	for field, v := range fields {
+		name := base
+		if field != "value" {
+			name += "_" + field
+		}
+		named[name] = v
+	}
+	return named
+}
+
+// tags applies the rename and drop rules
+func (n *naming) tags(pt *client.Point) {
+	if len(n.rename) == 0 && len(n.drop) == 0 {
+		return
+	}
+	tags := make(map[string]string, len(pt.Tags))
+	for k, v := range pt.Tags {
+		if n.drop[k] {
+			continue
+		}
+		if to, ok := n.rename[k]; ok {
+			k = to
+		}
+		tags[k] = v
+	}
+	pt.Tags = tags
+}
+
+// snake converts names such as 'ifHCInOctets' to 'if_hc_in_octets'
+func snake(s string) string {
+	runes := []rune(s)
+	var b bytes.Buffer
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == ' ' || r == '.':
+			b.WriteRune('_')
+			continue
+		case unicode.IsUpper(r) && i > 0:
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	gotest "testing" // as 'testing' is the flag
+
+	"github.com/influxdb/influxdb/client"
+)
+
+func TestSnake(t *gotest.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ifHCInOctets", "if_hc_in_octets"},
+		{"sysUpTime", "sys_up_time"},
+		{"hrProcessorLoad", "hr_processor_load"},
+		{"ifIn1pps", "if_in1pps"},
+		{"dot1dTpFdbPort", "dot1d_tp_fdb_port"},
+		{"ipv6IfIndex", "ipv6_if_index"},
+		{"mib-2", "mib_2"},
+		{"CPU Load.5", "cpu_load_5"},
+		{"ALLCAPS", "allcaps"},
+		{"value", "value"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := snake(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseNaming(t *gotest.T) {
+	data := &nameData{Mib: "ifXEntry", Group: "switch", Column: "ifHCInOctets", Row: "uplink", Host: "sw1"}
+	tests := []struct {
+		mib         MibConfig
+		measurement string
+		field       string
+		tags        map[string]string // from host=sw1, column=uplink
+		err         bool
+	}{
+		{
+			mib:         MibConfig{},
+			measurement: "default", field: "value",
+			tags: map[string]string{"host": "sw1", "column": "uplink"},
+		},
+		{
+			mib: MibConfig{
+				Measurement: "net_{{.Mib | lower}}",
+				Field:       `{{.Column | snake | trimPrefix "if_"}}`,
+				TagRename:   []string{"column:interface"},
+				TagDrop:     []string{"host"},
+			},
+			measurement: "net_ifxentry", field: "hc_in_octets",
+			tags: map[string]string{"interface": "uplink"},
+		},
+		{
+			mib:         MibConfig{Measurement: `{{.Group | upper}}_{{.Host | replace "sw" "switch"}}`, Field: "{{.Row | trimSuffix \"link\"}}"},
+			measurement: "SWITCH_switch1", field: "up",
+			tags: map[string]string{"host": "sw1", "column": "uplink"},
+		},
+		{
+			// an empty result falls back to the default
+			mib:         MibConfig{Measurement: "{{if false}}x{{end}}"},
+			measurement: "default", field: "value",
+			tags: map[string]string{"host": "sw1", "column": "uplink"},
+		},
+		{
+			// as does one that fails when run
+			mib:         MibConfig{Measurement: "{{.Nope}}"},
+			measurement: "default", field: "value",
+			tags: map[string]string{"host": "sw1", "column": "uplink"},
+		},
+		{mib: MibConfig{Measurement: "{{.Mib"}, err: true},
+		{mib: MibConfig{Field: "{{nosuchfunc .Column}}"}, err: true},
+		{mib: MibConfig{TagRename: []string{"column"}}, err: true},
+		{mib: MibConfig{TagRename: []string{":interface"}}, err: true},
+		{mib: MibConfig{TagRename: []string{"column:"}}, err: true},
+	}
+	for i, tt := range tests {
+		n, err := parseNaming(&tt.mib)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d: no error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			continue
+		}
+		if got := n.name(n.measurement, data, "default"); got != tt.measurement {
+			t.Errorf("%d: measurement %q, want %q", i, got, tt.measurement)
+		}
+		field := n.name(n.field, data, "value")
+		if field != tt.field {
+			t.Errorf("%d: field %q, want %q", i, field, tt.field)
+		}
+		fields := n.fields(map[string]interface{}{"value": 1, "rate": 2.0}, field)
+		want := map[string]interface{}{tt.field: 1, tt.field + "_rate": 2.0}
+		if !reflect.DeepEqual(fields, want) {
+			t.Errorf("%d: fields %v, want %v", i, fields, want)
+		}
+		pt := client.Point{Tags: map[string]string{"host": "sw1", "column": "uplink"}}
+		n.tags(&pt)
+		if !reflect.DeepEqual(pt.Tags, tt.tags) {
+			t.Errorf("%d: tags %v, want %v", i, pt.Tags, tt.tags)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// port is how an interface is written, its label is the column tag
+type port struct {
+	label string
+	tags  map[string]string
+}
+
+// portRule matches interface names by pattern, either a glob such as
+// 'ge-0/0/*' or 'regex:^Ethernet1/(\d+)$', where the label and tags can
+// refer to what was matched with $1 etc. Exclude rules start with '!'
+type portRule struct {
+	text    string // as given in the portfile
+	match   *regexp.Regexp
+	port    port
+	exclude bool
+}
+
+// parsePort reads the label and any 'key=value' tags for a portfile entry
+func parsePort(fields []string) (port, error) {
+	var p port
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		p.label, fields = fields[0], fields[1:]
+	}
+	for _, f := range fields {
+		i := strings.Index(f, "=")
+		if i < 1 || i == len(f)-1 {
+			return p, fmt.Errorf("invalid tag: %s", f)
+		}
+		if err := reservedTag(f[:i]); err != nil {
+			return p, err
+		}
+		if p.tags == nil {
+			p.tags = make(map[string]string)
+		}
+		p.tags[f[:i]] = f[i+1:]
+	}
+	return p, nil
+}
+
+// isPattern reports if the portfile entry is a rule rather than a name
+func isPattern(s string) bool {
+	return strings.HasPrefix(s, "!") || strings.HasPrefix(s, "regex:") ||
+		strings.ContainsAny(s, "*?")
+}
+
+// globRegexp turns a glob into a regexp, with each wildcard captured
+func globRegexp(glob string) string {
+	var re []string
+	for _, r := range glob {
+		switch r {
+		case '*':
+			re = append(re, "(.*)")
+		case '?':
+			re = append(re, "(.)")
+		default:
+			re = append(re, regexp.QuoteMeta(string(r)))
+		}
+	}
+	return "^" + strings.Join(re, "") + "$"
+}
+
+func parsePortRule(fields []string) (portRule, error) {
+	pattern := fields[0]
+	rule := portRule{text: strings.Join(fields, " ")}
+	var err error
+	if rule.port, err = parsePort(fields[1:]); err != nil {
+		return rule, err
+	}
+	if strings.HasPrefix(pattern, "!") {
+		rule.exclude = true
+		pattern = pattern[1:]
+	}
+	expr := globRegexp(pattern)
+	if strings.HasPrefix(pattern, "regex:") {
+		expr = strings.TrimPrefix(pattern, "regex:")
+	}
+	if rule.match, err = regexp.Compile(expr); err != nil {
+		return rule, fmt.Errorf("invalid port rule %s: %s", pattern, err)
+	}
+	return rule, nil
+}
+
+// portFor returns how the interface is written, if it is to be polled.
+// Excludes take precedence, then exact names, then the first rule to match
+func (c *SnmpConfig) portFor(name string) (port, bool) {
+	for _, r := range c.rules {
+		if r.exclude && r.match.MatchString(name) {
+			return port{}, false
+		}
+	}
+	if p, ok := c.ports[name]; ok {
+		return p, true
+	}
+	for _, r := range c.rules {
+		if r.exclude {
+			continue
+		}
+		m := r.match.FindStringSubmatchIndex(name)
+		if m == nil {
+			continue
+		}
+		expand := func(s string) string {
+			return string(r.match.ExpandString(nil, s, name, m))
+		}
+		p := port{label: name}
+		if len(r.port.label) > 0 {
+			p.label = expand(r.port.label)
+		}
+		if len(r.port.tags) > 0 {
+			p.tags = make(map[string]string, len(r.port.tags))
+			for k, v := range r.port.tags {
+				p.tags[k] = expand(v)
+			}
+		}
+		return p, true
+	}
+	return port{}, false
+}
+
+// samePorts reports if the portfile rules are the same
+func samePorts(a, b []portRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].text != b[i].text {
+			return false
+		}
+	}
+	return true
+}
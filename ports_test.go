@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	gotest "testing" // as 'testing' is the flag
+)
+
+func TestParsePortRule(t *gotest.T) {
+	tests := []struct {
+		line    string
+		match   []string
+		nomatch []string
+		exclude bool
+		port    port
+		err     bool
+	}{
+		{line: "ge-0/0/* uplink-$1", match: []string{"ge-0/0/1", "ge-0/0/"}, nomatch: []string{"xe-0/0/1", "age-0/0/1"},
+			port: port{label: "uplink-$1"}},
+		{line: "ge-?/0/1", match: []string{"ge-1/0/1"}, nomatch: []string{"ge-10/0/1"}},
+		{line: "Gi1.0*", match: []string{"Gi1.01"}, nomatch: []string{"Gi1x01"}},
+		{line: `regex:^Ethernet1/(\d+)$ port$1 speed=10g`, match: []string{"Ethernet1/12"}, nomatch: []string{"Ethernet1/x"},
+			port: port{label: "port$1", tags: map[string]string{"speed": "10g"}}},
+		{line: "!ge-0/0/47", match: []string{"ge-0/0/47"}, exclude: true},
+		{line: "lo* role=loopback", match: []string{"lo0"}, port: port{tags: map[string]string{"role": "loopback"}}},
+		{line: "regex:( x", err: true},
+		{line: "ge-* uplink host=x", err: true},
+		{line: "ge-* uplink column=x", err: true},
+		{line: "ge-* uplink speed=", err: true},
+		{line: "ge-* uplink =10g", err: true},
+	}
+	for _, tt := range tests {
+		rule, err := parsePortRule(strings.Fields(tt.line))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.line, err)
+			continue
+		}
+		for _, name := range tt.match {
+			if !rule.match.MatchString(name) {
+				t.Errorf("%s: doesn't match %s", tt.line, name)
+			}
+		}
+		for _, name := range tt.nomatch {
+			if rule.match.MatchString(name) {
+				t.Errorf("%s: matches %s", tt.line, name)
+			}
+		}
+		if rule.exclude != tt.exclude || !reflect.DeepEqual(rule.port, tt.port) || rule.text != tt.line {
+			t.Errorf("%s: got %+v", tt.line, rule)
+		}
+	}
+}
+
+func TestPortFor(t *gotest.T) {
+	c := &SnmpConfig{ports: map[string]port{
+		"xe-0/0/1": {label: "aws", tags: map[string]string{"provider": "aws"}},
+		"ge-0/0/9": {label: "nine"},
+		"ge-0/0/5": {label: "five"},
+	}}
+	for _, line := range []string{
+		"ge-0/0/* uplink-$1 circuit=C$1",
+		`regex:^Ethernet(\d+)/(\d+)$ port$1-$2`,
+		"!ge-0/0/9",
+		"lo*",
+		"ge-* other",
+	} {
+		rule, err := parsePortRule(strings.Fields(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.rules = append(c.rules, rule)
+	}
+	tests := []struct {
+		name string
+		ok   bool
+		want port
+	}{
+		{"xe-0/0/1", true, port{label: "aws", tags: map[string]string{"provider": "aws"}}},
+		// exact names come before rules
+		{"ge-0/0/5", true, port{label: "five"}},
+		{"ge-0/0/3", true, port{label: "uplink-3", tags: map[string]string{"circuit": "C3"}}},
+		{"Ethernet1/12", true, port{label: "port1-12"}},
+		// without a label the name is used
+		{"lo0", true, port{label: "lo0"}},
+		// the first rule to match wins
+		{"ge-1/0/1", true, port{label: "other"}},
+		// excludes win over exact names
+		{"ge-0/0/9", false, port{}},
+		{"em0", false, port{}},
+	}
+	for _, tt := range tests {
+		got, ok := c.portFor(tt.name)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v %v, want %+v %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
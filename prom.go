@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/soniah/gosnmp"
+)
+
+// promSeries is the latest value of a polled metric
+type promSeries struct {
+	labels  string
+	value   float64
+	expires time.Time
+}
+
+// promFamily is all series of a metric name
+type promFamily struct {
+	kind    string // counter or gauge
+	series  map[string]*promSeries
+	clashed bool // a column of the other kind has been seen
+}
+
+// promCache holds the latest polled values for the /metrics endpoint
+type promCache struct {
+	sync.Mutex
+	families map[string]*promFamily
+}
+
+var (
+	metrics       = &promCache{families: make(map[string]*promFamily)}
+	promNameChars = strings.NewReplacer("-", "_", ".", "_", " ", "_", "/", "_")
+	promEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func promName(name string) string {
+	name = promNameChars.Replace(name)
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+func promLabels(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]string, 0, len(keys))
+	for _, k := range keys {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, strings.Replace(promName(k), ":", "_", -1), promEscaper.Replace(tags[k])))
+	}
+	return strings.Join(labels, ",")
+}
+
+// record saves the numeric fields of the point, the raw value is typed
+// by its pdu type and anything computed from it is a gauge. Columns of a
+// measurement can differ in type so counters are named with a _total
+// suffix, anything that still clashes with its family's type is skipped.
+func (p *promCache) record(pt client.Point, kind gosnmp.Asn1BER, ttl time.Duration) {
+	labels := promLabels(pt.Tags)
+	expires := time.Now().Add(ttl)
+	p.Lock()
+	defer p.Unlock()
+	for field, v := range pt.Fields {
+		value, ok := numericValue(v)
+		if !ok {
+			continue
+		}
+		name := "snmp_" + pt.Measurement
+		if field != "value" {
+			name += "_" + field
+		}
+		typ := "gauge"
+		if field == "value" && (kind == gosnmp.Counter32 || kind == gosnmp.Counter64) {
+			typ = "counter"
+			name += "_total"
+		}
+		name = promName(name)
+		f, ok := p.families[name]
+		if !ok {
+			f = &promFamily{kind: typ, series: make(map[string]*promSeries)}
+			p.families[name] = f
+		}
+		if f.kind != typ {
+			if !f.clashed {
+				log.Printf("metric %s is a %s, skipping %s {%s}\n", name, f.kind, typ, labels)
+				f.clashed = true
+			}
+			continue
+		}
+		f.series[labels] = &promSeries{labels, value, expires}
+	}
+}
+
+// render writes the metrics in the prometheus text format,
+// series that have not been updated recently are dropped
+func (p *promCache) render(w io.Writer) {
+	now := time.Now()
+	p.Lock()
+	defer p.Unlock()
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		keys := make([]string, 0, len(f.series))
+		for k, s := range f.series {
+			if now.After(s.expires) {
+				delete(f.series, k)
+				continue
+			}
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			delete(p.families, name)
+			continue
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s} %g\n", name, k, f.series[k].value)
+		}
+	}
+}
+
+func MetricsPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.render(w)
+}
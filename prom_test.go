@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	gotest "testing" // as 'testing' is the flag
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/soniah/gosnmp"
+)
+
+func TestPromRecord(t *gotest.T) {
+	p := &promCache{families: make(map[string]*promFamily)}
+	tests := []struct {
+		measurement string
+		host        string
+		kind        gosnmp.Asn1BER
+		fields      map[string]interface{}
+	}{
+		{"ifHCInOctets", "sw1", gosnmp.Counter64, map[string]interface{}{"value": uint64(10), "rate": 2.5}},
+		// the same column as a gauge from another device
+		{"ifHCInOctets", "sw2", gosnmp.Gauge32, map[string]interface{}{"value": int64(7)}},
+		{"sysDescr", "sw1", gosnmp.OctetString, map[string]interface{}{"value": "router"}},
+		// a gauge named like a counter is skipped
+		{"ifHCInOctets_total", "sw3", gosnmp.Gauge32, map[string]interface{}{"value": int64(3)}},
+	}
+	for _, tt := range tests {
+		pt := client.Point{Measurement: tt.measurement, Tags: map[string]string{"host": tt.host}, Fields: tt.fields}
+		p.record(pt, tt.kind, time.Minute)
+	}
+	var b bytes.Buffer
+	p.render(&b)
+	want := `# TYPE snmp_ifHCInOctets gauge
+snmp_ifHCInOctets{host="sw2"} 7
+# TYPE snmp_ifHCInOctets_rate gauge
+snmp_ifHCInOctets_rate{host="sw1"} 2.5
+# TYPE snmp_ifHCInOctets_total counter
+snmp_ifHCInOctets_total{host="sw1"} 10
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
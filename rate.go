@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+const sysUpTimeOid = ".1.3.6.1.2.1.1.3.0"
+
+// counterSample is the previous reading of a counter
+type counterSample struct {
+	value uint64
+	when  time.Time
+}
+
+func validRate(rate string) bool {
+	switch rate {
+	case "", "add", "only":
+		return true
+	}
+	return false
+}
+
+// checkUptime polls sysUpTime so that counter resets caused
+// by a device restart aren't mistaken for counter wraps
+func (c *SnmpConfig) checkUptime(snmp *gosnmp.GoSNMP) error {
+	c.incRequests()
+	pkt, err := snmp.Get([]string{sysUpTimeOid})
+	if err != nil {
+		return err
+	}
+	c.incGets()
+	for _, pdu := range pkt.Variables {
+		if pdu.Type != gosnmp.TimeTicks {
+			continue
+		}
+		ticks := gosnmp.ToBigInt(pdu.Value).Uint64()
+		now := time.Now()
+		if ticks < c.uptime && !uptimeWrapped(c.uptime, ticks, now.Sub(c.uptimeAt)) {
+			log.Println("sysUpTime went backwards, resetting counters for:", c.Host)
+			c.samples = nil
+			c.rebooted = true
+		}
+		c.uptime, c.uptimeAt = ticks, now
+	}
+	return nil
+}
+
+// uptimeWrapped reports if sysUpTime going backwards is explained by
+// the 32 bit TimeTicks wrapping (after 497 days) since the last reading
+func uptimeWrapped(prev, ticks uint64, elapsed time.Duration) bool {
+	hundredths := uint64(elapsed / (10 * time.Millisecond))
+	// allow for the device's clock and the polls not quite keeping time
+	slack := hundredths/10 + 1000
+	return prev+hundredths+slack >= 1<<32 && ticks <= hundredths+slack
+}
+
+// counterRate adds the per second rate of change of a counter
+// as a 'rate' field, dropping the raw value if so configured.
+// Samples are kept by group and instance, as labels needn't be unique
+// and the same column can be polled by more than one group
+func (c *SnmpConfig) counterRate(group, rate string, val *pduValue, now time.Time) {
+	if len(rate) == 0 {
+		return
+	}
+	var wrap uint64
+	switch val.kind {
+	case gosnmp.Counter32:
+		wrap = 1 << 32
+	case gosnmp.Counter64:
+	default:
+		return
+	}
+	if c.samples == nil {
+		c.samples = make(map[string]counterSample)
+	}
+	cur := gosnmp.ToBigInt(val.value).Uint64()
+	key := group + "\t" + val.oid
+	prev, ok := c.samples[key]
+	c.samples[key] = counterSample{cur, now}
+	if rate == "only" {
+		val.value = nil
+	}
+	if !ok {
+		return
+	}
+	secs := now.Sub(prev.when).Seconds()
+	if secs <= 0 {
+		return
+	}
+	var delta uint64
+	switch {
+	case cur >= prev.value:
+		delta = cur - prev.value
+	case wrap > 0:
+		delta = wrap - prev.value + cur
+	default:
+		// a 64 bit counter won't wrap, so it must have been reset
+		return
+	}
+	val.addField("rate", float64(delta)/secs)
+}
+
+// octet counters that utilisation can be worked out for
+var octetColumns = map[string]bool{
+	"ifInOctets":    true,
+	"ifOutOctets":   true,
+	"ifHCInOctets":  true,
+	"ifHCOutOctets": true,
+}
+
+// speedColumn is a column giving the speed of a row, times scale in bps
+type speedColumn struct {
+	column string
+	scale  float64
+}
+
+// ifSpeed maxes out at 4.2Gbps, so ifHighSpeed is preferred
+var defaultSpeeds = []speedColumn{{"ifHighSpeed", 1e6}, {"ifSpeed", 1}}
+
+// validUtilisation checks that what utilisation needs is being polled,
+// and parses the 'speed = column[:scale]' entries, in order of preference
+func validUtilisation(m *MibConfig) error {
+	if !m.Utilisation {
+		return nil
+	}
+	if len(m.Rate) == 0 {
+		return fmt.Errorf("utilisation requires a rate")
+	}
+	polled := make(map[string]bool)
+	var octets bool
+	for _, col := range m.Columns {
+		octets = octets || octetColumns[col]
+		polled[col] = true
+	}
+	if !octets {
+		return fmt.Errorf("utilisation requires an octets column, e.g., ifHCInOctets")
+	}
+	m.speeds = nil
+	for _, s := range m.Speed {
+		sc := speedColumn{column: s, scale: 1}
+		if i := strings.Index(s, ":"); i >= 0 {
+			f, err := strconv.ParseFloat(s[i+1:], 64)
+			if err != nil || f <= 0 {
+				return fmt.Errorf("invalid speed scale: %s", s)
+			}
+			sc.column, sc.scale = s[:i], f
+		}
+		if !polled[sc.column] {
+			return fmt.Errorf("speed column %s isn't polled by the mib config", sc.column)
+		}
+		m.speeds = append(m.speeds, sc)
+	}
+	if len(m.Speed) == 0 {
+		for _, sc := range defaultSpeeds {
+			if polled[sc.column] {
+				m.speeds = append(m.speeds, sc)
+			}
+		}
+	}
+	if len(m.speeds) == 0 {
+		return fmt.Errorf("utilisation requires an ifHighSpeed or ifSpeed column (or a speed setting) in the same mib config")
+	}
+	return nil
+}
+
+// utilisation adds 'bps' and 'utilisation' (percent of the interface
+// speed) fields to the octet counters that have a rate
+func (g *mibGroup) utilisation(vals []*pduValue) {
+	if !g.mib.Utilisation {
+		return
+	}
+	// interface speed by row instance, in bits per second,
+	// from the most preferred speed column that has one
+	speeds := make(map[string]float64)
+	rank := make(map[string]int)
+	for _, val := range vals {
+		v, ok := numericValue(val.value)
+		if !ok || v <= 0 {
+			continue
+		}
+		for i, sc := range g.mib.speeds {
+			if val.name != sc.column {
+				continue
+			}
+			if r, ok := rank[val.suffix]; !ok || i < r {
+				speeds[val.suffix], rank[val.suffix] = v*sc.scale, i
+			}
+			break
+		}
+	}
+	for _, val := range vals {
+		if !octetColumns[val.name] {
+			continue
+		}
+		rate, ok := numericValue(val.fields["rate"])
+		if !ok {
+			continue
+		}
+		bps := rate * 8
+		val.addField("bps", bps)
+		speed, ok := speeds[val.suffix]
+		if !ok {
+			if g.noSpeed == nil {
+				g.noSpeed = make(map[string]bool)
+			}
+			if !g.noSpeed[val.suffix] {
+				log.Printf("%s: no speed for %s (%s), leaving out its utilisation\n", g.name, val.column, val.suffix)
+				g.noSpeed[val.suffix] = true
+			}
+			continue
+		}
+		val.addField("utilisation", bps/speed*100)
+	}
+}
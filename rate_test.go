@@ -0,0 +1,225 @@
+package main
+
+import (
+	"reflect"
+	gotest "testing" // as 'testing' is the flag
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+func TestCounterRate(t *gotest.T) {
+	start := time.Unix(1500000000, 0)
+	tests := []struct {
+		name       string
+		rate       string
+		kind       gosnmp.Asn1BER
+		prev, cur  uint64
+		secs       int
+		reboot     bool // between the samples
+		want       float64
+		hasRate    bool
+		keepsValue bool
+	}{
+		{"counter32", "add", gosnmp.Counter32, 100, 1100, 10, false, 100, true, true},
+		{"counter32 wrap", "add", gosnmp.Counter32, 1<<32 - 100, 400, 10, false, 50, true, true},
+		{"counter64", "add", gosnmp.Counter64, 1e12, 1e12 + 8e9, 10, false, 8e8, true, true},
+		{"counter64 reset", "add", gosnmp.Counter64, 1e12, 5, 10, false, 0, false, true},
+		{"reboot", "add", gosnmp.Counter32, 100, 1100, 10, true, 0, false, true},
+		{"same time", "add", gosnmp.Counter32, 100, 1100, 0, false, 0, false, true},
+		{"rate only", "only", gosnmp.Counter64, 0, 300, 30, false, 10, true, false},
+		{"not a counter", "add", gosnmp.Gauge32, 100, 1100, 10, false, 0, false, true},
+		{"no rate", "", gosnmp.Counter32, 100, 1100, 10, false, 0, false, true},
+	}
+	for _, tt := range tests {
+		c := &SnmpConfig{}
+		prev := &pduValue{name: "ifHCInOctets", oid: "1.3.6.1.2.1.31.1.1.1.6.1", kind: tt.kind, value: tt.prev}
+		c.counterRate("switch", tt.rate, prev, start)
+		if _, ok := prev.fields["rate"]; ok {
+			t.Errorf("%s: rate from the first sample", tt.name)
+		}
+		if tt.reboot {
+			c.samples = nil // as checkUptime does
+		}
+		cur := &pduValue{name: "ifHCInOctets", oid: "1.3.6.1.2.1.31.1.1.1.6.1", kind: tt.kind, value: tt.cur}
+		c.counterRate("switch", tt.rate, cur, start.Add(time.Duration(tt.secs)*time.Second))
+		rate, ok := cur.fields["rate"]
+		if ok != tt.hasRate || (ok && rate != tt.want) {
+			t.Errorf("%s: rate %v %v, want %v %v", tt.name, rate, ok, tt.want, tt.hasRate)
+		}
+		if (cur.value != nil) != tt.keepsValue {
+			t.Errorf("%s: value %v", tt.name, cur.value)
+		}
+	}
+}
+
+func TestCounterRateKeys(t *gotest.T) {
+	start := time.Unix(1500000000, 0)
+	c := &SnmpConfig{}
+	type sample struct {
+		group, oid string
+		value      uint64
+	}
+	// rows with the same label, and the same column in two groups
+	first := []sample{
+		{"switch", "1.3.6.1.2.1.31.1.1.1.6.1", 1000},
+		{"switch", "1.3.6.1.2.1.31.1.1.1.6.2", 5000},
+		{"uplinks", "1.3.6.1.2.1.31.1.1.1.6.1", 1000},
+	}
+	for _, s := range first {
+		c.counterRate(s.group, "add", &pduValue{name: "ifHCInOctets", column: "eth", oid: s.oid,
+			kind: gosnmp.Counter64, value: s.value}, start)
+	}
+	want := []float64{10, 20, 10}
+	for i, s := range first {
+		val := &pduValue{name: "ifHCInOctets", column: "eth", oid: s.oid,
+			kind: gosnmp.Counter64, value: s.value + uint64(want[i]*10)}
+		c.counterRate(s.group, "add", val, start.Add(10*time.Second))
+		if rate := val.fields["rate"]; rate != want[i] {
+			t.Errorf("%s %s: rate %v, want %v", s.group, s.oid, rate, want[i])
+		}
+	}
+}
+
+func TestUptimeWrapped(t *gotest.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		name        string
+		prev, ticks uint64
+		elapsed     time.Duration
+		want        bool
+	}{
+		{"wrapped after 497 days", 1<<32 - 3000, 3000, time.Minute, true},
+		{"wrapped exactly", 1<<32 - 6000, 0, time.Minute, true},
+		{"rebooted", 1 << 31, 500, time.Minute, false},
+		{"rebooted near the wrap", 1<<32 - 3000, 500000, time.Minute, false},
+		{"wrapped during an outage", 1<<32 - 3000, 2*8640000 - 3000, 2 * day, true},
+	}
+	for _, tt := range tests {
+		if got := uptimeWrapped(tt.prev, tt.ticks, tt.elapsed); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidUtilisation(t *gotest.T) {
+	tests := []struct {
+		mib    MibConfig
+		speeds []speedColumn
+		err    bool
+	}{
+		{mib: MibConfig{Columns: []string{"ifHCInOctets"}}},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets", "ifHighSpeed"}}},
+		{mib: MibConfig{Utilisation: true, Rate: "only", Columns: []string{"ifInOctets", "ifOutOctets", "ifSpeed"}}},
+		{mib: MibConfig{Utilisation: true, Columns: []string{"ifHCInOctets", "ifHighSpeed"}}, err: true},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInUcastPkts", "ifHighSpeed"}}, err: true},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets"}}, err: true},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets", "ifSpeed", "ifHighSpeed"}},
+			speeds: []speedColumn{{"ifHighSpeed", 1e6}, {"ifSpeed", 1}}},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets", "portSpeed", "ifSpeed"},
+			Speed: []string{"portSpeed:1000", "ifSpeed"}},
+			speeds: []speedColumn{{"portSpeed", 1000}, {"ifSpeed", 1}}},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets", "portSpeed"},
+			Speed: []string{"ifSpeed"}}, err: true},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets", "portSpeed"},
+			Speed: []string{"portSpeed:kbps"}}, err: true},
+		{mib: MibConfig{Utilisation: true, Rate: "add", Columns: []string{"ifHCInOctets", "portSpeed"},
+			Speed: []string{"portSpeed:0"}}, err: true},
+	}
+	for _, tt := range tests {
+		err := validUtilisation(&tt.mib)
+		if (err != nil) != tt.err {
+			t.Errorf("%+v: error %v", tt.mib, err)
+		}
+		if tt.speeds != nil && !reflect.DeepEqual(tt.mib.speeds, tt.speeds) {
+			t.Errorf("%+v: speeds %v, want %v", tt.mib, tt.mib.speeds, tt.speeds)
+		}
+	}
+}
+
+func TestUtilisation(t *gotest.T) {
+	type want struct {
+		bps, utilisation float64
+		hasBps, hasUtil  bool
+	}
+	rate := func(name, suffix string, rate float64) *pduValue {
+		return &pduValue{name: name, column: "eth", suffix: suffix, value: uint64(1),
+			fields: map[string]interface{}{"rate": rate}}
+	}
+	speed := func(name, suffix string, v interface{}) *pduValue {
+		return &pduValue{name: name, column: "eth", suffix: suffix, value: v}
+	}
+	tests := []struct {
+		name   string
+		speeds []speedColumn // ifHighSpeed then ifSpeed if not set
+		vals   []*pduValue
+		want   []want // for the first values
+	}{
+		{
+			name: "ifHighSpeed",
+			vals: []*pduValue{rate("ifHCInOctets", "1", 1.25e6), speed("ifHighSpeed", "1", int64(100))},
+			want: []want{{1e7, 10, true, true}},
+		},
+		{
+			name: "ifSpeed",
+			vals: []*pduValue{rate("ifInOctets", "1", 1.25e5), speed("ifSpeed", "1", int64(1e7))},
+			want: []want{{1e6, 10, true, true}},
+		},
+		{
+			name: "ifHighSpeed preferred",
+			vals: []*pduValue{rate("ifHCOutOctets", "1", 1.25e6), speed("ifSpeed", "1", int64(4294967295)),
+				speed("ifHighSpeed", "1", int64(10))},
+			want: []want{{1e7, 100, true, true}},
+		},
+		{
+			// rows with the same label are matched by instance
+			name: "by instance",
+			vals: []*pduValue{rate("ifHCInOctets", "1", 1.25e6), rate("ifHCInOctets", "2", 1.25e6),
+				speed("ifHighSpeed", "2", int64(1000)), speed("ifHighSpeed", "1", int64(100))},
+			want: []want{{1e7, 10, true, true}, {1e7, 1, true, true}},
+		},
+		{
+			name:   "speed column",
+			speeds: []speedColumn{{"portSpeed", 1000}, {"ifHighSpeed", 1e6}},
+			vals: []*pduValue{rate("ifHCInOctets", "1", 1.25e6), speed("ifHighSpeed", "1", int64(1000)),
+				speed("portSpeed", "1", int64(1e5))},
+			want: []want{{1e7, 10, true, true}},
+		},
+		{
+			// rows without one get no utilisation
+			name:   "other speed",
+			speeds: []speedColumn{{"portSpeed", 1000}},
+			vals:   []*pduValue{rate("ifHCInOctets", "1", 1.25e6), speed("ifHighSpeed", "1", int64(100))},
+			want:   []want{{1e7, 0, true, false}},
+		},
+		{
+			name: "no speed",
+			vals: []*pduValue{rate("ifHCInOctets", "1", 1.25e6), speed("ifHighSpeed", "1", int64(0))},
+			want: []want{{1e7, 0, true, false}},
+		},
+		{
+			name: "no rate",
+			vals: []*pduValue{{name: "ifHCInOctets", suffix: "1", value: uint64(5)}, speed("ifHighSpeed", "1", int64(100))},
+			want: []want{{}},
+		},
+		{
+			name: "not octets",
+			vals: []*pduValue{rate("ifHCInUcastPkts", "1", 100), speed("ifHighSpeed", "1", int64(100))},
+			want: []want{{}},
+		},
+	}
+	for _, tt := range tests {
+		g := &mibGroup{name: tt.name, mib: &MibConfig{Utilisation: true, speeds: tt.speeds}}
+		if tt.speeds == nil {
+			g.mib.speeds = defaultSpeeds
+		}
+		g.utilisation(tt.vals)
+		for i, w := range tt.want {
+			bps, hasBps := tt.vals[i].fields["bps"]
+			util, hasUtil := tt.vals[i].fields["utilisation"]
+			if hasBps != w.hasBps || hasUtil != w.hasUtil || (hasBps && bps != w.bps) || (hasUtil && util != w.utilisation) {
+				t.Errorf("%s %d: bps %v utilisation %v, want %+v", tt.name, i, bps, util, w)
+			}
+		}
+	}
+}
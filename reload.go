@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+var (
+	reloadLock sync.Mutex
+	// influx configs replaced by a reload that are still being flushed
+	retiring sync.WaitGroup
+)
+
+// sameSettings compares the values set by the config file
+func sameSettings(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if len(t.Field(i).Tag.Get("gcfg")) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// reloadConfig re-reads the config, portfiles and oids, starting new devices,
+// stopping removed ones and restarting those that changed. Influx configs
+// that are unchanged carry on as they were so nothing queued is lost.
+// New devices are prepared without holding reloadLock, as an unreachable
+// one can take a while, and the swap fails if another reload got in first.
+func reloadConfig() error {
+	conf, err := readConfig()
+	if err != nil {
+		return err
+	}
+	oidsChanged, err := loadOids()
+	if err != nil {
+		return err
+	}
+	cfgLock.RLock()
+	old := cfg
+	cfgLock.RUnlock()
+
+	if conf.HTTP.Port != old.HTTP.Port || !sameSettings(&conf.Traps, &old.Traps) {
+		log.Println("changes to the http and traps settings require a restart")
+	}
+
+	// influx configs that are new or have changed need connecting,
+	// anything that fails leaves things as they are
+	influxChanged := make(map[string]bool)
+	for name, i := range conf.Influx {
+		if o, ok := old.Influx[name]; ok && sameSettings(i, o) && o.iChan != nil {
+			conf.Influx[name] = o
+			continue
+		}
+		influxChanged[name] = true
+	}
+
+	// figure out which devices need to be (re)started
+	var started, stopped []*SnmpConfig
+	outputs := make(map[*SnmpConfig][]string)
+	for name, c := range conf.Snmp {
+		if outputs[c], err = conf.outputs(c); err != nil {
+			return err
+		}
+		if o, ok := old.Snmp[name]; ok && !oidsChanged && sameSettings(c, o) &&
+			sameGroups(c.groups, o.groups) && reflect.DeepEqual(c.ports, o.ports) && reflect.DeepEqual(c.static, o.static) && samePorts(c.rules, o.rules) &&
+			reflect.DeepEqual(outputs[c], o.targets) {
+			same := true
+			for _, out := range outputs[c] {
+				same = same && !influxChanged[out]
+			}
+			if same {
+				conf.Snmp[name] = o
+				continue
+			}
+		}
+		if err := c.prepare(); err != nil {
+			return fmt.Errorf("snmp config: %s error: %s", name, err)
+		}
+		started = append(started, c)
+	}
+	for name, o := range old.Snmp {
+		if c, ok := conf.Snmp[name]; !ok || c != o {
+			stopped = append(stopped, o)
+		}
+	}
+	for name := range influxChanged {
+		if err := conf.Influx[name].Connect(); err != nil {
+			return fmt.Errorf("influx config: %s error: %s", name, err)
+		}
+	}
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	cfgLock.RLock()
+	current := cfg
+	cfgLock.RUnlock()
+	if current != old {
+		return fmt.Errorf("config changed by another reload, try again")
+	}
+
+	// the point of no return
+	for _, c := range stopped {
+		log.Println("stopping:", c.name)
+		c.Stop()
+	}
+	for name, o := range old.Influx {
+		if i, ok := conf.Influx[name]; (ok && i == o) || o.iChan == nil {
+			continue
+		}
+		log.Println("closing influx:", name)
+		if o.spool != nil {
+			// the replacement may be using the same spool
+			if err := o.Close(); err != nil {
+				errMsg("influx close error", err)
+			}
+			continue
+		}
+		retiring.Add(1)
+		go func(o *InfluxConfig) {
+			defer retiring.Done()
+			if err := o.Flush(shutdownTimeout); err != nil {
+				errMsg("influx flush error", err)
+			}
+			o.Close()
+		}(o)
+	}
+	for _, c := range started {
+		for _, out := range outputs[c] {
+			i := conf.Influx[out]
+			if i.iChan == nil {
+				if err := i.start(); err != nil {
+					errMsg("influx start error", err)
+				}
+			}
+			c.sinks = append(c.sinks, i)
+		}
+		c.targets = outputs[c]
+		log.Println("starting:", c.name)
+		c.start(0)
+	}
+
+	cfgLock.Lock()
+	cfg = conf
+	cfgLock.Unlock()
+	if traps.Port > 0 {
+		traps.Init(conf.Snmp)
+	}
+	log.Printf("config reloaded, %d devices started, %d stopped\n", len(started), len(stopped))
+	return nil
+}
+
+// reloader reloads the config when sent a SIGHUP
+func reloader() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(); err != nil {
+			errMsg("config reload failed", err)
+		}
+	}
+}
+
+func ReloadPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "reload requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		errMsg("config reload failed", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
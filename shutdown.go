@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdown stops polling and flushes everything queued for influx,
+// returning the exit status
+func shutdown() int {
+	reloadLock.Lock()
+	cfgLock.RLock()
+	conf := cfg
+	cfgLock.RUnlock()
+
+	// stop scheduling polls and let those in progress complete
+	var wg sync.WaitGroup
+	for _, c := range conf.Snmp {
+		wg.Add(1)
+		go func(c *SnmpConfig) {
+			c.Stop()
+			wg.Done()
+		}(c)
+	}
+	wg.Wait()
+	traps.Close()
+
+	status := 0
+	deadline := time.Now().Add(shutdownTimeout)
+	for name, i := range conf.Influx {
+		if i.iChan == nil {
+			continue // not in use
+		}
+		if err := i.Flush(deadline.Sub(time.Now())); err != nil {
+			errMsg("influx flush error", err)
+			status = 1
+		}
+		if err := i.Close(); err != nil {
+			errMsg("influx close error", err)
+			status = 1
+		}
+		if i.Spooled() > 0 {
+			log.Printf("influx %s: %d batches left in spool\n", name, i.Spooled())
+		}
+	}
+	// as well as those replaced by a reload
+	retired := make(chan struct{})
+	go func() {
+		retiring.Wait()
+		close(retired)
+	}()
+	select {
+	case <-retired:
+	case <-time.After(deadline.Sub(time.Now())):
+		errLog("%s\tinflux flush of replaced outputs timed out\n", time.Now().Format(layout))
+		status = 1
+	}
+	log.Println("shutdown complete")
+	errorLog.Close()
+	return status
+}
+
+// shutdowner waits for SIGINT or SIGTERM to shut down cleanly,
+// a second signal exits immediately
+func shutdowner() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	s := <-sig
+	log.Println("received:", s, "- shutting down")
+	go func() {
+		<-sig
+		log.Println("exiting without flushing")
+		os.Exit(2)
+	}()
+	os.Exit(shutdown())
+}
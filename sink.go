@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// Sink is a destination for the points gathered from snmp devices
+type Sink interface {
+	// Send queues points for delivery, it must not modify them
+	// as they are shared with other sinks
+	Send(points []client.Point)
+	// Flush waits until all queued points are delivered
+	Flush(timeout time.Duration) error
+	// Close stops delivery, pending points are left where they are
+	Close() error
+	Stats() SinkStats
+}
+
+// SinkStats summarizes a sink for the status page
+type SinkStats struct {
+	Name    string
+	Target  string
+	Sent    int64
+	Errors  int64
+	Pending int
+	Dropped int64
+}
+
+// send hands off the points to every sink configured for the device
+func (c *SnmpConfig) send(points []client.Point) {
+	if len(points) == 0 {
+		return
+	}
+	for _, s := range c.sinks {
+		s.Send(points)
+	}
+}
+
+// Outputs describes the sinks used by the device
+func (c *SnmpConfig) Outputs() []SinkStats {
+	stats := make([]SinkStats, 0, len(c.sinks))
+	for _, s := range c.sinks {
+		stats = append(stats, s.Stats())
+	}
+	return stats
+}
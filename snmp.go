@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/influxdb/influxdb/client"
 	"github.com/soniah/gosnmp"
 )
 
@@ -20,6 +21,7 @@ type Control struct {
 }
 
 var (
+	gatherers sync.WaitGroup
 	errorSNMP int
 	nameOid   = "1.3.6.1.2.1.31.1.1.1.1" // ifName
 )
@@ -30,121 +32,138 @@ const (
 
 type pduValue struct {
 	name, column string
+	suffix       string // instance of the row, labels needn't be unique
+	oid          string // of the instance
 	value        interface{}
+	kind         gosnmp.Asn1BER
+	fields       map[string]interface{} // computed values, e.g., rate
+	tags         map[string]string      // from the tag columns of the row
 }
 
-func getPoint(cfg *SnmpConfig, pdu gosnmp.SnmpPDU) *pduValue {
-	i := strings.LastIndex(pdu.Name, ".")
-	root := pdu.Name[1:i]
-	suffix := pdu.Name[i+1:]
-	col := cfg.labels[cfg.asOID[suffix]]
-	name, ok := oidToName[root]
-	if verbose {
-		log.Println("ROOT:", root, "SUFFIX:", suffix, "COL:", col, "NAME:", "VALUE:", pdu.Value)
+// gather polls the groups that are due, sending what was collected
+func (c *SnmpConfig) gather(snmp *gosnmp.GoSNMP, tick time.Duration) error {
+	now := time.Now()
+	var due []*mibGroup
+	uptime := false
+	for _, g := range c.groups {
+		if g.due(now, tick) {
+			due = append(due, g)
+			// needed for counter rates, and to spot a reboot renumbering rows
+			uptime = uptime || len(g.mib.Rate) > 0 || !g.mib.Scalers
+		}
 	}
-	if !ok {
-		log.Printf("Invalid oid: %s\n", pdu.Name)
-		return nil
+	if uptime {
+		if err := c.checkUptime(snmp); err != nil {
+			errLog("SNMP (%s) uptime error: %s\n", c.Host, err)
+			c.incErrors()
+			c.LastError = now
+			c.setProblem(err)
+			return err
+		}
+	}
+	c.reindex(snmp, now)
+	var points []client.Point
+	for _, g := range due {
+		if !g.mib.Scalers && c.indexed.IsZero() {
+			continue // the rows aren't known yet
+		}
+		g.next = now.Add(g.freq)
+		pts, err := c.poll(snmp, g, now)
+		points = append(points, pts...)
+		if err != nil {
+			errLog("SNMP (%s) get error: %s\n", c.Host, err)
+			c.incErrors()
+			c.LastError = now
+			c.setProblem(err)
+			c.send(points)
+			return err
+		}
 	}
-	if len(col) == 0 {
-		log.Println("empty col for:", cfg.asOID[suffix])
-		return nil // not an OID of interest
+	c.send(points)
+	if !c.indexed.IsZero() {
+		c.setProblem(nil)
 	}
-	return &pduValue{name, col, pdu.Value}
+	return nil
 }
 
-func bulkPoint(cfg *SnmpConfig, pdu gosnmp.SnmpPDU) *pduValue {
-	i := strings.LastIndex(pdu.Name, ".")
-	root := pdu.Name[1:i]
-	suffix := pdu.Name[i+1:]
-	col := cfg.asOID[suffix]
-	name, ok := oidToName[root]
-	if verbose {
-		log.Println("ROOT:", root, "SUFFIX:", suffix, "COL:", col, "NAME:", "VALUE:", pdu.Value)
+// interval is how often the groups need checking
+func (c *SnmpConfig) interval() time.Duration {
+	gcd := func(a, b time.Duration) time.Duration {
+		for b > 0 {
+			a, b = b, a%b
+		}
+		return a
 	}
-	if !ok {
-		log.Printf("Invalid oid: %s\n", pdu.Name)
-		return nil
+	var tick time.Duration
+	for _, g := range c.groups {
+		tick = gcd(tick, g.freq)
 	}
-	if len(col) == 0 {
-		log.Println("empty col for:", suffix)
-		return nil // not an OID of interest
+	if tick == 0 {
+		tick = time.Duration(c.Freq) * time.Second
 	}
-	return &pduValue{name, col, pdu.Value}
+	return tick
 }
 
-func snmpStats(snmp *gosnmp.GoSNMP, cfg *SnmpConfig) error {
-	now := time.Now()
-	if cfg == nil {
-		log.Fatal("cfg is nil")
-	}
-	if cfg.Influx == nil {
-		log.Fatal("influx cfg is nil")
-	}
-	bps := cfg.Influx.BP()
-	// we can only get 'maxOids' worth of snmp requests at a time
-	for i := 0; i < len(cfg.oids); i += maxOids {
-		end := i + maxOids
-		if end > len(cfg.oids) {
-			end = len(cfg.oids)
-		}
-		cfg.incRequests()
-		pkt, err := snmp.Get(cfg.oids[i:end])
+const (
+	sysNameOid     = ".1.3.6.1.2.1.1.5.0"
+	sysLocationOid = ".1.3.6.1.2.1.1.6.0"
+)
+
+// loadSysTags adds the sysName and sysLocation of the device to the
+// tags from the config, if so configured
+func (c *SnmpConfig) loadSysTags(client *gosnmp.GoSNMP) error {
+	tags := make(map[string]string)
+	if c.SysTags {
+		c.incRequests()
+		pkt, err := client.Get([]string{sysNameOid, sysLocationOid})
 		if err != nil {
-			errLog("SNMP (%s) get error: %s\n", cfg.Host, err)
-			cfg.incErrors()
-			cfg.LastError = now
-			return err
-		}
-		cfg.incGets()
-		if verbose {
-			log.Println("SNMP GET CNT:", len(pkt.Variables))
+			return fmt.Errorf("SNMP get error: %s", err)
 		}
+		c.incGets()
 		for _, pdu := range pkt.Variables {
-			val := getPoint(cfg, pdu)
-			if val == nil {
+			v := convertValue(pdu.Type, pdu.Value, "")
+			if v == nil || len(fmt.Sprint(v)) == 0 {
 				continue
 			}
-			if val.value == nil {
-				continue
+			switch pdu.Name {
+			case sysNameOid:
+				tags["sysName"] = fmt.Sprint(v)
+			case sysLocationOid:
+				tags["sysLocation"] = fmt.Sprint(v)
 			}
-			pt := makePoint(cfg.Host, val, now)
-			bps.Points = append(bps.Points, pt)
-
 		}
 	}
-	cfg.Influx.Send(bps)
+	// what's configured takes precedence
+	for k, v := range c.static {
+		tags[k] = v
+	}
+	c.tagLock.Lock()
+	c.tags = tags
+	c.tagLock.Unlock()
 	return nil
 }
 
-func bulkStats(snmp *gosnmp.GoSNMP, cfg *SnmpConfig) error {
-	now := time.Now()
-	if cfg == nil {
-		log.Fatal("cfg is nil")
-	}
-	if cfg.Influx == nil {
-		log.Fatal("influx cfg is nil")
-	}
-	bps := cfg.Influx.BP()
-	addPacket := func(pdu gosnmp.SnmpPDU) error {
-		val := bulkPoint(cfg, pdu)
-		if val != nil && val.value != nil {
-			pt := makePoint(cfg.Host, val, now)
-			bps.Points = append(bps.Points, pt)
-		}
-		return nil
+// Tags returns the tags for every point of the device
+func (c *SnmpConfig) Tags() map[string]string {
+	c.tagLock.RLock()
+	defer c.tagLock.RUnlock()
+	return c.tags
+}
+
+// walk uses GETBULK where the device supports it,
+// and falls back to GETNEXT for SNMPv1 devices
+func walk(client *gosnmp.GoSNMP, oid string, fn gosnmp.WalkFunc) error {
+	if client.Version == gosnmp.Version1 {
+		return client.Walk(oid, fn)
 	}
-	for i := 0; i < len(cfg.oids); i += 1 {
-		cfg.incRequests()
-		if err := snmp.BulkWalk(cfg.oids[i], addPacket); err != nil {
-			errLog("SNMP (%s) get error: %s\n", cfg.Host, err)
-			cfg.incErrors()
-			cfg.LastError = now
-			return err
-		}
+	return client.BulkWalk(oid, fn)
+}
+
+func walkAll(client *gosnmp.GoSNMP, oid string) ([]gosnmp.SnmpPDU, error) {
+	if client.Version == gosnmp.Version1 {
+		return client.WalkAll(oid)
 	}
-	cfg.Influx.Send(bps)
-	return nil
+	return client.BulkWalkAll(oid)
 }
 
 func printSnmpNames(c *SnmpConfig) {
@@ -153,9 +172,9 @@ func printSnmpNames(c *SnmpConfig) {
 		fatal(err)
 	}
 	defer client.Conn.Close()
-	pdus, err := client.BulkWalkAll(nameOid)
+	pdus, err := walkAll(client, nameOid)
 	if err != nil {
-		fatal("SNMP bulkwalk error", err)
+		fatal("SNMP walk error", err)
 	}
 	for _, pdu := range pdus {
 		switch pdu.Type {
@@ -165,6 +184,81 @@ func printSnmpNames(c *SnmpConfig) {
 	}
 }
 
+// usm returns the SNMPv3 security settings for the device
+func (s *SnmpConfig) usm() (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters, error) {
+	usm := &gosnmp.UsmSecurityParameters{
+		UserName:                 s.Username,
+		AuthenticationPassphrase: s.AuthPass,
+		PrivacyPassphrase:        s.PrivPass,
+	}
+	if len(s.Username) == 0 {
+		return 0, nil, fmt.Errorf("snmpv3 requires a username for: %s", s.Host)
+	}
+	switch strings.ToUpper(s.AuthProto) {
+	case "", "NOAUTH":
+		usm.AuthenticationProtocol = gosnmp.NoAuth
+	case "MD5":
+		usm.AuthenticationProtocol = gosnmp.MD5
+	case "SHA":
+		usm.AuthenticationProtocol = gosnmp.SHA
+	default:
+		return 0, nil, fmt.Errorf("invalid authproto for %s: %s", s.Host, s.AuthProto)
+	}
+	switch strings.ToUpper(s.PrivProto) {
+	case "", "NOPRIV":
+		usm.PrivacyProtocol = gosnmp.NoPriv
+	case "DES":
+		usm.PrivacyProtocol = gosnmp.DES
+	case "AES":
+		usm.PrivacyProtocol = gosnmp.AES
+	default:
+		return 0, nil, fmt.Errorf("invalid privproto for %s: %s", s.Host, s.PrivProto)
+	}
+
+	if len(s.PrivPass) > 0 && len(s.AuthPass) == 0 {
+		return 0, nil, fmt.Errorf("privpass requires an authpass for: %s", s.Host)
+	}
+
+	// security level defaults to whatever the credentials given allow
+	level := strings.ToLower(s.SecLevel)
+	if len(level) == 0 {
+		switch {
+		case len(s.AuthPass) == 0:
+			level = "noauthnopriv"
+		case len(s.PrivPass) == 0:
+			level = "authnopriv"
+		default:
+			level = "authpriv"
+		}
+	}
+	var flags gosnmp.SnmpV3MsgFlags
+	switch level {
+	case "noauthnopriv":
+		flags = gosnmp.NoAuthNoPriv
+	case "authnopriv":
+		flags = gosnmp.AuthNoPriv
+	case "authpriv":
+		flags = gosnmp.AuthPriv
+	default:
+		return 0, nil, fmt.Errorf("invalid seclevel for %s: %s", s.Host, s.SecLevel)
+	}
+	// the protocols have to be given, guessing wrong just looks
+	// like the wrong password to the device
+	if flags != gosnmp.NoAuthNoPriv && len(s.AuthPass) == 0 {
+		return 0, nil, fmt.Errorf("seclevel %s requires an authpass for: %s", level, s.Host)
+	}
+	if flags != gosnmp.NoAuthNoPriv && usm.AuthenticationProtocol == gosnmp.NoAuth {
+		return 0, nil, fmt.Errorf("seclevel %s requires an authproto for: %s", level, s.Host)
+	}
+	if flags == gosnmp.AuthPriv && len(s.PrivPass) == 0 {
+		return 0, nil, fmt.Errorf("seclevel %s requires a privpass for: %s", level, s.Host)
+	}
+	if flags == gosnmp.AuthPriv && usm.PrivacyProtocol == gosnmp.NoPriv {
+		return 0, nil, fmt.Errorf("seclevel %s requires a privproto for: %s", level, s.Host)
+	}
+	return flags, usm, nil
+}
+
 func snmpClient(s *SnmpConfig) (*gosnmp.GoSNMP, error) {
 	client := &gosnmp.GoSNMP{
 		Target:    s.Host,
@@ -174,6 +268,23 @@ func snmpClient(s *SnmpConfig) (*gosnmp.GoSNMP, error) {
 		Timeout:   time.Duration(s.Timeout) * time.Second,
 		Retries:   s.Retries,
 	}
+	switch s.Version {
+	case "1":
+		client.Version = gosnmp.Version1
+	case "", "2", "2c":
+	case "3":
+		flags, usm, err := s.usm()
+		if err != nil {
+			return client, err
+		}
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = flags
+		client.SecurityParameters = usm
+		client.ContextName = s.ContextName
+	default:
+		return client, fmt.Errorf("invalid snmp version for %s: %s", s.Host, s.Version)
+	}
 	err := client.Connect()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -192,20 +303,24 @@ func (s *SnmpConfig) DebugLog() *log.Logger {
 }
 
 func (s *SnmpConfig) Gather(count int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(s.done)
 	debug := false
-	client, err := snmpClient(s)
-	if err != nil {
-		fatal(err)
+	client, ok := s.connect()
+	if !ok {
+		return
 	}
-	defer client.Conn.Close()
-	spew(strings.Join(s.oids, "\n"))
-	fn := snmpStats
-	if len(s.PortFile) == 0 {
-		fn = bulkStats
+	defer func() {
+		client.Conn.Close()
+	}()
+	for _, g := range s.groups {
+		spew(strings.Join(g.oids, "\n"))
 	}
-	c := time.Tick(time.Duration(s.Freq) * time.Second)
+	tick := s.interval()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
 	for {
-		err := fn(client, s)
+		err := s.gather(client, tick)
 		if count > 0 {
 			count--
 			if count == 0 {
@@ -216,12 +331,8 @@ func (s *SnmpConfig) Gather(count int, wg *sync.WaitGroup) {
 		if err != nil {
 			errLog("snmp error - reloading snmp client: %s", err)
 			client.Conn.Close()
-			for {
-				if client, err = snmpClient(s); err == nil {
-					break
-				}
-				errLog("snmp client connect error: %s", err)
-				time.Sleep(time.Duration(s.Timeout) * time.Second)
+			if client, ok = s.connect(); !ok {
+				return
 			}
 		}
 
@@ -229,8 +340,10 @@ func (s *SnmpConfig) Gather(count int, wg *sync.WaitGroup) {
 	LOOP:
 		for {
 			select {
-			case <-c:
+			case <-ticker.C:
 				break LOOP
+			case <-s.stop:
+				return
 			case debug := <-s.debugging:
 				log.Println("debugging:", debug)
 				if debug && client.Logger == nil {
@@ -243,5 +356,50 @@ func (s *SnmpConfig) Gather(count int, wg *sync.WaitGroup) {
 			}
 		}
 	}
-	wg.Done()
+}
+
+// the least time between attempts to connect to a device
+const minConnectDelay = 5 * time.Second
+
+// connect keeps trying to create a client, waiting longer after each
+// failure, giving up only if the device is stopped
+func (s *SnmpConfig) connect() (*gosnmp.GoSNMP, bool) {
+	delay := time.Duration(s.Timeout) * time.Second
+	if delay < minConnectDelay {
+		delay = minConnectDelay
+	}
+	for {
+		client, err := snmpClient(s)
+		if err == nil {
+			return client, true
+		}
+		errLog("snmp client connect error: %s\n", err)
+		s.setProblem(err)
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			return client, false
+		}
+		if delay *= 2; delay > maxRetry {
+			delay = maxRetry
+		}
+	}
+}
+
+// start polling the device in the background
+func (s *SnmpConfig) start(count int) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	gatherers.Add(1)
+	go s.Gather(count, &gatherers)
+}
+
+// Stop polling the device, waiting for any poll in progress to complete
+func (s *SnmpConfig) Stop() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	gotest "testing" // as 'testing' is the flag
+
+	"github.com/soniah/gosnmp"
+)
+
+func TestUsm(t *gotest.T) {
+	tests := []struct {
+		name  string
+		s     *SnmpConfig
+		flags gosnmp.SnmpV3MsgFlags
+		auth  gosnmp.SnmpV3AuthProtocol
+		priv  gosnmp.SnmpV3PrivProtocol
+		err   bool
+	}{
+		{name: "no auth", s: &SnmpConfig{Username: "u"},
+			flags: gosnmp.NoAuthNoPriv, auth: gosnmp.NoAuth, priv: gosnmp.NoPriv},
+		{name: "auth", s: &SnmpConfig{Username: "u", AuthProto: "sha", AuthPass: "a"},
+			flags: gosnmp.AuthNoPriv, auth: gosnmp.SHA, priv: gosnmp.NoPriv},
+		{name: "auth priv", s: &SnmpConfig{Username: "u", AuthProto: "MD5", AuthPass: "a", PrivProto: "aes", PrivPass: "p"},
+			flags: gosnmp.AuthPriv, auth: gosnmp.MD5, priv: gosnmp.AES},
+		{name: "lower level", s: &SnmpConfig{Username: "u", AuthProto: "SHA", AuthPass: "a", PrivProto: "DES", PrivPass: "p", SecLevel: "authNoPriv"},
+			flags: gosnmp.AuthNoPriv, auth: gosnmp.SHA, priv: gosnmp.DES},
+		{name: "no username", s: &SnmpConfig{AuthProto: "SHA", AuthPass: "a"}, err: true},
+		{name: "no authproto", s: &SnmpConfig{Username: "u", AuthPass: "a"}, err: true},
+		{name: "no privproto", s: &SnmpConfig{Username: "u", AuthProto: "SHA", AuthPass: "a", PrivPass: "p"}, err: true},
+		{name: "privpass only", s: &SnmpConfig{Username: "u", PrivProto: "AES", PrivPass: "p"}, err: true},
+		{name: "no authpass", s: &SnmpConfig{Username: "u", AuthProto: "SHA", SecLevel: "authNoPriv"}, err: true},
+		{name: "no privpass", s: &SnmpConfig{Username: "u", AuthProto: "SHA", AuthPass: "a", PrivProto: "AES", SecLevel: "authPriv"}, err: true},
+		{name: "bad authproto", s: &SnmpConfig{Username: "u", AuthProto: "SHA512", AuthPass: "a"}, err: true},
+		{name: "bad privproto", s: &SnmpConfig{Username: "u", AuthProto: "SHA", AuthPass: "a", PrivProto: "3DES", PrivPass: "p"}, err: true},
+		{name: "bad seclevel", s: &SnmpConfig{Username: "u", SecLevel: "all"}, err: true},
+	}
+	for _, tt := range tests {
+		flags, usm, err := tt.s.usm()
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if flags != tt.flags || usm.AuthenticationProtocol != tt.auth || usm.PrivacyProtocol != tt.priv {
+			t.Errorf("%s: got %v %v %v", tt.name, flags, usm.AuthenticationProtocol, usm.PrivacyProtocol)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+const spoolExt = ".bp"
+
+// spoolFile is a batch of points saved to disk
+type spoolFile struct {
+	name   string
+	size   int64
+	when   time.Time
+	points int64
+}
+
+// spool is a write-ahead queue of batches kept on disk so that
+// points survive influxdb outages as well as restarts of this process
+type spool struct {
+	sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	files   []spoolFile
+	size    int64
+	seq     int64
+	ready   chan struct{}
+	Dropped int64 // points dropped due to size or age limits
+}
+
+// spool files are named by time, sequence and point count so they
+// sort in the order they were written
+func parseSpoolName(name string) (spoolFile, bool) {
+	f := strings.Split(strings.TrimSuffix(name, spoolExt), ".")
+	if len(f) != 3 || !strings.HasSuffix(name, spoolExt) {
+		return spoolFile{}, false
+	}
+	nano, err1 := strconv.ParseInt(f[0], 10, 64)
+	points, err2 := strconv.ParseInt(f[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return spoolFile{}, false
+	}
+	return spoolFile{name: name, when: time.Unix(0, nano), points: points}, true
+}
+
+func openSpool(dir string, maxSize int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		ready:   make(chan struct{}, 1),
+	}
+	for _, fi := range infos {
+		if strings.HasSuffix(fi.Name(), ".tmp") {
+			// left over from a crash part way through a write
+			if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				errLog("spool remove error: %s\n", err)
+			}
+			continue
+		}
+		if f, ok := parseSpoolName(fi.Name()); ok {
+			f.size = fi.Size()
+			s.files = append(s.files, f)
+			s.size += f.size
+		}
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	if len(s.files) > 0 {
+		log.Printf("replaying %d spooled batches from: %s\n", len(s.files), dir)
+		s.signal()
+	}
+	return s, nil
+}
+
+func (s *spool) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns a channel that is signalled when batches are added
+func (s *spool) wait() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.ready
+}
+
+// put saves the batch to disk, making room by dropping the oldest batches
+func (s *spool) put(bps *client.BatchPoints) error {
+	s.Lock()
+	defer s.Unlock()
+	s.seq++
+	now := time.Now()
+	name := fmt.Sprintf("%019d.%06d.%d%s", now.UnixNano(), s.seq%1000000, len(bps.Points), spoolExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(file).Encode(bps); err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fi, err := os.Stat(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, spoolFile{name: name, size: fi.Size(), when: now, points: int64(len(bps.Points))})
+	s.size += fi.Size()
+	for s.maxSize > 0 && s.size > s.maxSize && len(s.files) > 1 {
+		errLog("%s\tspool %s full, dropping batch: %s\n", now.Format(layout), s.dir, s.files[0].name)
+		s.drop()
+	}
+	s.signal()
+	return nil
+}
+
+// drop removes the oldest batch, counting its points as lost
+func (s *spool) drop() {
+	f := s.files[0]
+	atomic.AddInt64(&s.Dropped, f.points)
+	s.remove(f)
+}
+
+// remove deletes a batch once it has been sent
+func (s *spool) remove(f spoolFile) {
+	if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil && !os.IsNotExist(err) {
+		errLog("spool remove error: %s\n", err)
+	}
+	for i := range s.files {
+		if s.files[i].name == f.name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			s.size -= f.size
+			break
+		}
+	}
+}
+
+// Done deletes a batch that has been delivered
+func (s *spool) Done(f spoolFile) {
+	s.Lock()
+	s.remove(f)
+	s.Unlock()
+}
+
+// next returns the oldest batch, discarding any that are past their age limit
+func (s *spool) next() (spoolFile, *client.BatchPoints, bool) {
+	s.Lock()
+	defer s.Unlock()
+	for len(s.files) > 0 {
+		f := s.files[0]
+		if s.maxAge > 0 && time.Since(f.when) > s.maxAge {
+			errLog("%s\tspool batch expired: %s\n", time.Now().Format(layout), f.name)
+			s.drop()
+			continue
+		}
+		file, err := os.Open(filepath.Join(s.dir, f.name))
+		if err != nil {
+			errLog("spool open error: %s\n", err)
+			s.drop()
+			continue
+		}
+		var bps client.BatchPoints
+		err = gob.NewDecoder(file).Decode(&bps)
+		file.Close()
+		if err != nil {
+			errLog("spool decode error (%s): %s\n", f.name, err)
+			s.drop()
+			continue
+		}
+		return f, &bps, true
+	}
+	return spoolFile{}, nil, false
+}
+
+// Pending is the number of batches waiting to be sent
+func (s *spool) Pending() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.files)
+}
+
+// Bytes is the disk space used by pending batches
+func (s *spool) Bytes() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
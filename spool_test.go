@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	gotest "testing" // as 'testing' is the flag
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+func spoolBatch(i int64, points int) *client.BatchPoints {
+	bps := &client.BatchPoints{Database: "snmp", RetentionPolicy: "default"}
+	for j := 0; j < points; j++ {
+		bps.Points = append(bps.Points, client.Point{
+			Measurement: "ifHCInOctets",
+			Tags:        map[string]string{"host": "sw1", "column": "ge-0/0/1"},
+			Fields:      map[string]interface{}{"value": uint64(i), "rate": 1.5, "n": i, "s": "up"},
+			Time:        time.Unix(1500000000+i, 0),
+		})
+	}
+	return bps
+}
+
+func TestSpool(t *gotest.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name    string
+		put     int                     // batches of 2 points
+		damage  func(s *spool) []string // done to the files before reopening
+		maxSize int64
+		want    []int64 // batches read back
+		dropped int64
+	}{
+		{name: "round trip", put: 3, want: []int64{0, 1, 2}},
+		{
+			name: "truncated batch",
+			put:  3,
+			damage: func(s *spool) []string {
+				name := filepath.Join(s.dir, s.files[1].name)
+				fi, _ := os.Stat(name)
+				os.Truncate(name, fi.Size()/2)
+				return nil
+			},
+			want:    []int64{0, 2},
+			dropped: 2,
+		},
+		{
+			name: "empty batch",
+			put:  2,
+			damage: func(s *spool) []string {
+				os.Truncate(filepath.Join(s.dir, s.files[0].name), 0)
+				return nil
+			},
+			want:    []int64{1},
+			dropped: 2,
+		},
+		{
+			name: "partly written batch",
+			put:  1,
+			damage: func(s *spool) []string {
+				tmp := filepath.Join(s.dir, "0000000000000000001.000009.2.bp.tmp")
+				ioutil.WriteFile(tmp, []byte("partial"), 0644)
+				return []string{tmp}
+			},
+			want: []int64{0},
+		},
+		{name: "too big", put: 3, maxSize: 1, want: []int64{2}, dropped: 4},
+	}
+	for _, tt := range tests {
+		sdir := filepath.Join(dir, tt.name)
+		s, err := openSpool(sdir, tt.maxSize, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < tt.put; i++ {
+			if err := s.put(spoolBatch(int64(i), 2)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var gone []string
+		if tt.damage != nil {
+			gone = tt.damage(s)
+		}
+		dropped := s.Dropped
+		if s, err = openSpool(sdir, tt.maxSize, 0); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range gone {
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("%s: %s not removed", tt.name, name)
+			}
+		}
+		var got []int64
+		for {
+			f, bps, ok := s.next()
+			if !ok {
+				break
+			}
+			if len(bps.Points) != 2 || bps.Database != "snmp" {
+				t.Errorf("%s: read back %+v", tt.name, bps)
+			}
+			pt := bps.Points[0]
+			n, _ := pt.Fields["n"].(int64)
+			if pt.Fields["value"] != uint64(n) || pt.Fields["rate"] != 1.5 || pt.Fields["s"] != "up" ||
+				pt.Tags["column"] != "ge-0/0/1" || !pt.Time.Equal(time.Unix(1500000000+n, 0)) {
+				t.Errorf("%s: read back %+v", tt.name, pt)
+			}
+			got = append(got, n)
+			s.Done(f)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got batches %v, want %v", tt.name, got, tt.want)
+		}
+		if dropped+s.Dropped != tt.dropped {
+			t.Errorf("%s: dropped %d, want %d", tt.name, dropped+s.Dropped, tt.dropped)
+		}
+		if s.Pending() != 0 || s.Bytes() != 0 {
+			t.Errorf("%s: %d batches %d bytes left", tt.name, s.Pending(), s.Bytes())
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+	"github.com/soniah/gosnmp"
+)
+
+const (
+	snmpTrapOid   = "1.3.6.1.6.3.1.1.4.1.0"
+	genericTrapV1 = "1.3.6.1.6.3.1.1.5"
+)
+
+type TrapConfig struct {
+	Port        int    `gcfg:"port"`
+	Listen      string `gcfg:"listen"`
+	Community   string `gcfg:"community"`
+	Measurement string `gcfg:"measurement"`
+	Username    string `gcfg:"username"`
+	AuthProto   string `gcfg:"authproto"`
+	AuthPass    string `gcfg:"authpass"`
+	PrivProto   string `gcfg:"privproto"`
+	PrivPass    string `gcfg:"privpass"`
+	SecLevel    string `gcfg:"seclevel"`
+	Received    int64
+	Informs     int64
+	Unknown     int64
+	Rejected    int64
+	sources     map[string]*SnmpConfig
+	listener    *gosnmp.TrapListener
+	sync.RWMutex
+}
+
+// lookupOid finds the closest named parent of the oid,
+// returning the name and the remaining instance suffix
+func lookupOid(oid string) (string, string) {
+	oid = strings.TrimPrefix(oid, ".")
+	for root := oid; len(root) > 0; {
+		if name, ok := oidName(root); ok {
+			return name, strings.TrimPrefix(oid[len(root):], ".")
+		}
+		i := strings.LastIndex(root, ".")
+		if i < 0 {
+			break
+		}
+		root = root[:i]
+	}
+	return oid, ""
+}
+
+// trapOid returns the identity of the trap, v1 traps are mapped
+// as described in RFC 3584
+func trapOid(p *gosnmp.SnmpPacket) string {
+	if p.Version == gosnmp.Version1 {
+		if p.GenericTrap < 6 {
+			return fmt.Sprintf("%s.%d", genericTrapV1, p.GenericTrap+1)
+		}
+		return fmt.Sprintf("%s.0.%d", strings.TrimPrefix(p.Enterprise, "."), p.SpecificTrap)
+	}
+	for _, pdu := range p.Variables {
+		if strings.TrimPrefix(pdu.Name, ".") == snmpTrapOid {
+			if s, ok := pdu.Value.(string); ok {
+				return strings.TrimPrefix(s, ".")
+			}
+		}
+	}
+	return ""
+}
+
+// source matches the sender to a polled device so the event
+// is tagged and written the same as that device's data
+func (t *TrapConfig) source(p *gosnmp.SnmpPacket, addr *net.UDPAddr) *SnmpConfig {
+	t.RLock()
+	defer t.RUnlock()
+	if c, ok := t.sources[addr.IP.String()]; ok {
+		return c
+	}
+	return t.sources[p.AgentAddress]
+}
+
+func (t *TrapConfig) handle(p *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+	atomic.AddInt64(&t.Received, 1)
+	if p.PDUType == gosnmp.InformRequest {
+		atomic.AddInt64(&t.Informs, 1)
+	}
+	if p.Version != gosnmp.Version3 && len(t.Community) > 0 && p.Community != t.Community {
+		atomic.AddInt64(&t.Rejected, 1)
+		log.Println("trap with invalid community from:", addr.IP)
+		return
+	}
+	c := t.source(p, addr)
+	if c == nil {
+		atomic.AddInt64(&t.Unknown, 1)
+		spew("trap from unknown source:", addr.IP)
+		return
+	}
+	oid := trapOid(p)
+	name, suffix := lookupOid(oid)
+	if len(suffix) > 0 {
+		name += "." + suffix
+	}
+	common := map[string]interface{}{
+		"oid": oid,
+	}
+	// varbinds of table rows by instance (e.g., the ifIndex), as each
+	// is written as a point of its own tagged with it
+	rows := make(map[string]map[string]interface{})
+	var order []string
+	for _, pdu := range p.Variables {
+		vname, vsuffix := lookupOid(pdu.Name)
+		switch strings.TrimPrefix(pdu.Name, ".") {
+		case snmpTrapOid:
+			continue
+		case strings.TrimPrefix(sysUpTimeOid, "."):
+			vname, vsuffix = "uptime", ""
+		}
+		v := convertValue(pdu.Type, pdu.Value, "")
+		if v == nil {
+			continue
+		}
+		if len(vsuffix) == 0 || vsuffix == "0" {
+			common[vname] = v
+			continue
+		}
+		fields, ok := rows[vsuffix]
+		if !ok {
+			fields = make(map[string]interface{})
+			rows[vsuffix] = fields
+			order = append(order, vsuffix)
+		}
+		fields[vname] = v
+	}
+	if len(order) == 0 {
+		order = append(order, "")
+	}
+	now := time.Now()
+	points := make([]client.Point, 0, len(order))
+	for _, index := range order {
+		tags := map[string]string{}
+		for k, v := range c.Tags() {
+			tags[k] = v
+		}
+		tags["host"] = c.Host
+		tags["trap"] = name
+		tags["source"] = addr.IP.String()
+		tags["version"] = p.Version.String()
+		if len(index) > 0 {
+			tags["index"] = index
+		}
+		fields := make(map[string]interface{}, len(common)+len(rows[index]))
+		for k, v := range common {
+			fields[k] = v
+		}
+		for k, v := range rows[index] {
+			fields[k] = v
+		}
+		points = append(points, client.Point{
+			Measurement: t.Measurement,
+			Tags:        tags,
+			Fields:      fields,
+			Time:        now,
+		})
+	}
+	spew("TRAP:", points)
+	c.send(points)
+}
+
+// Init maps the addresses of the polled devices for matching trap senders
+func (t *TrapConfig) Init(devices map[string]*SnmpConfig) {
+	if len(t.Measurement) == 0 {
+		t.Measurement = "snmp_trap"
+	}
+	sources := make(map[string]*SnmpConfig)
+	for _, c := range devices {
+		sources[c.Host] = c
+		addrs, err := net.LookupHost(c.Host)
+		if err != nil {
+			log.Println("trap source lookup error:", err)
+			continue
+		}
+		for _, addr := range addrs {
+			sources[addr] = c
+		}
+	}
+	t.Lock()
+	t.sources = sources
+	t.Unlock()
+}
+
+// Close stops listening for traps
+func (t *TrapConfig) Close() {
+	t.Lock()
+	defer t.Unlock()
+	if t.listener != nil {
+		t.listener.Close()
+		t.listener = nil
+	}
+}
+
+// trapListener receives traps and informs (which gosnmp acknowledges)
+func trapListener(t *TrapConfig) {
+	tl := gosnmp.NewTrapListener()
+	params := *gosnmp.Default
+	tl.Params = &params
+	if len(t.Username) > 0 {
+		// v3 credentials are checked the same as for polling
+		usmCfg := &SnmpConfig{
+			Host:      "trap listener",
+			Username:  t.Username,
+			AuthProto: t.AuthProto,
+			AuthPass:  t.AuthPass,
+			PrivProto: t.PrivProto,
+			PrivPass:  t.PrivPass,
+			SecLevel:  t.SecLevel,
+		}
+		flags, usm, err := usmCfg.usm()
+		if err != nil {
+			fatal("trap config error:", err)
+		}
+		tl.Params.Version = gosnmp.Version3
+		tl.Params.SecurityModel = gosnmp.UserSecurityModel
+		tl.Params.MsgFlags = flags
+		tl.Params.SecurityParameters = usm
+	}
+	tl.OnNewTrap = t.handle
+	t.Lock()
+	t.listener = tl
+	t.Unlock()
+	addr := net.JoinHostPort(t.Listen, strconv.Itoa(t.Port))
+	log.Println("listening for traps on:", addr)
+	if err := tl.Listen(addr); err != nil {
+		errMsg("trap listener error", err)
+	}
+}
@@ -60,17 +60,24 @@ div {
 <p>Started: {{.Started}}</p>
 <p>Uptime: {{.Uptime}}</p>
 <p><a href="/logs">Logs files</a></p>
+<p><a href="/metrics">Metrics</a></p>
+<form action="/reload" method="POST">
+<p>Config: <button type="submit">Reload</button></p>
+</form>
 <h1>Config</h1>
 {{ range $key,$snmp := .SNMP }}
 <div>
 <p class="snmp">SNMP {{$key}}</p>
 <p>Host: {{$snmp.Host}}</p>
 <p>Freq: {{$snmp.Freq}}</p>
+<p>Mibs: {{$snmp.Mibs}}</p>
+<p>Status: {{$snmp.Health}}</p>
 <p>Retries: {{$snmp.Retries}}</p>
 <p>Timeout: {{$snmp.Timeout}}</p>
 <p>Last Error: {{$snmp.LastError}}</p>
-<p>DB Host: {{$snmp.Influx.Hostname}}</p>
-<p>DB Name: {{$snmp.Influx.DB}}</p>
+{{ range $snmp.Outputs }}
+<p>Output: {{.Name}} ({{.Target}})</p>
+{{ end }}
 <p>Errors: {{.Errors}}</p>
 <p>Requests: {{.Requests}}</p>
 <p>Replies: {{.Gets}}</p>
@@ -85,9 +92,23 @@ SNMP Debugging:<button type="submit" value="{{.DebugAction}}">{{.DebugAction}}</
 <div>
 <p class="snmp">Influx {{$key}}</p>
 <p>Host: {{$influx.Host}}</p>
-<p>Database: {{$influx.DB}}</p>
+<p>Database: {{$influx.Database}}</p>
 <p>Sent: {{$influx.Sent}}</p>
 <p>Errors: {{$influx.Errors}}</p>
+{{ if $influx.Spool }}
+<p>Spooled: {{$influx.Spooled}} batches ({{$influx.SpoolBytes}} bytes)</p>
+<p>Dropped: {{$influx.Dropped}} points</p>
+{{ end }}
+</div>
+{{ end }}
+{{ if .Traps.Port }}
+<div>
+<p class="snmp">Traps</p>
+<p>Port: {{.Traps.Port}}</p>
+<p>Received: {{.Traps.Received}}</p>
+<p>Informs: {{.Traps.Informs}}</p>
+<p>Unknown Sources: {{.Traps.Unknown}}</p>
+<p>Rejected: {{.Traps.Rejected}}</p>
 </div>
 {{ end }}
 <p><a href="/debug/pprof/">Profiler</a></p>
@@ -133,6 +154,8 @@ var errs = template.Must(template.New("errors").Parse(errors))
 var logs = template.Must(template.New("logs").Parse(logfiles))
 
 func HomePage(w http.ResponseWriter, r *http.Request) {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
 	const layout = "Jan 2, 2006 at 3:04pm (MST)"
 	data := struct {
 		Period, Started string
@@ -141,6 +164,7 @@ func HomePage(w http.ResponseWriter, r *http.Request) {
 		DebugAction     string
 		SNMP            map[string]*SnmpConfig
 		Influx          map[string]*InfluxConfig
+		Traps           *TrapConfig
 	}{
 		LogFile: errorName,
 		Started: startTime.Format(layout),
@@ -148,8 +172,8 @@ func HomePage(w http.ResponseWriter, r *http.Request) {
 		Period:  errorPeriod,
 		SNMP:    cfg.Snmp,
 		Influx:  cfg.Influx,
+		Traps:   traps,
 	}
-
 	if err := tmpl.Execute(w, data); err != nil {
 		errLog("home error:%s\n", err)
 	}
@@ -185,12 +209,17 @@ func DebugPage(w http.ResponseWriter, r *http.Request) {
 		action := r.Form.Get("action")
 		host := r.Form.Get("host")
 		fmt.Println("debug action:", action, "host:", host, "debug", (action == "enable"))
+		cfgLock.RLock()
 		for _, c := range cfg.Snmp {
 			if host == c.Host {
-				c.debugging <- (action == "enable")
+				select {
+				case c.debugging <- (action == "enable"):
+				case <-c.done:
+				}
 				break
 			}
 		}
+		cfgLock.RUnlock()
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
@@ -257,6 +286,8 @@ var webHandlers = []HFunc{
 	{"/favicon.ico", FaviconPage},
 	{"/errors", ErrorsPage},
 	{"/snmp/debug", DebugPage},
+	{"/metrics", MetricsPage},
+	{"/reload", ReloadPage},
 	{"/", HomePage},
 }
 